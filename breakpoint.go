@@ -0,0 +1,644 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	breakpointTypeLinenum = iota
+	breakpointTypeOffset
+	breakpointTypeFilenameLinenum
+	breakpointTypeFunction
+	breakpointTypeFunctionLabel
+	breakpointTypeFilenameFunction
+	breakpointTypeLabel
+	breakpointTypeAddress
+	breakpointTypeWatchpoint
+	breakpointTypeCatchpoint
+)
+
+// https://sourceware.org/gdb/onlinedocs/gdb/Linespec-Locations.html#Linespec-Locations
+type breakpoint struct {
+	// Number by which the frontend knows the breakpoint.
+	number         int
+	rawLocation    string
+	breakpointType int
+	fileName       string
+	lineNo         int
+	function       string
+	label          string
+	dlvBreakpoint  *api.Breakpoint
+	// Whether to delete the breakpoint after it is hit (-t).
+	temporary bool
+	// Condition as specified by the frontend (-c). The condition
+	// Delve evaluates also takes threadID into account.
+	condition string
+	// How many times to ignore the breakpoint (-i).
+	ignoreCount int
+	// Delve's hit count when the ignore count was set; the hits
+	// that follow are the ones ignored.
+	ignoreBase uint64
+	// Goroutine the breakpoint is restricted to (-p), 0 for any.
+	threadID int
+	// Whether threadID is an OS thread ID (threads model).
+	osThread bool
+	// Whether to create the breakpoint even if its location
+	// cannot be resolved yet (-f).
+	pending bool
+	// For watchpoints, the last known value of the watched expression
+	// and the scope it is evaluated in: that of the goroutine and frame
+	// it was set in, the frame being counted from the bottom of the
+	// stack, so that it is found as the stack grows (see watchScope()).
+	watchValue           string
+	watchScope           api.EvalScope
+	watchFrameFromBottom int
+	watchFunction        string
+	// For dynamic printf, the format of what is printed when it is hit.
+	dprintfFormat string
+	// For catchpoints, what is caught: catchTypePanic or catchTypeThrow.
+	catchType string
+	// Commands to run when the breakpoint stops the target (-break-commands).
+	commands []string
+}
+
+func (bp breakpoint) String() string {
+	switch bp.breakpointType {
+	case breakpointTypeLinenum, breakpointTypeOffset, breakpointTypeFilenameLinenum:
+		return f("Breakpoint at %s:%d, Delve breakpoint: %+v", bp.fileName, bp.lineNo, *bp.dlvBreakpoint)
+	case breakpointTypeFunction:
+		return f("Breakpoint at function %s, Delve breakpoint: %+v", bp.function, *bp.dlvBreakpoint)
+	case breakpointTypeFunctionLabel, breakpointTypeLabel:
+		return f("Breakpoint at label %s in function %s, Delve breakpoint: %+v", bp.label, bp.function, *bp.dlvBreakpoint)
+	case breakpointTypeFilenameFunction:
+		return f("Breakpoint at function %s in %s, Delve breakpoint: %+v", bp.function, bp.fileName, *bp.dlvBreakpoint)
+	case breakpointTypeAddress:
+		return f("Breakpoint at address 0x%x, Delve breakpoint: %+v", bp.dlvBreakpoint.Addr, *bp.dlvBreakpoint)
+	case breakpointTypeWatchpoint:
+		return f("Watchpoint on %s, Delve breakpoint: %+v", bp.rawLocation, *bp.dlvBreakpoint)
+	case breakpointTypeCatchpoint:
+		return f("Catchpoint for %s, Delve breakpoint: %+v", bp.catchType, *bp.dlvBreakpoint)
+	default:
+		return "Impossible breakpoint"
+	}
+}
+
+// newBreakpoint parses the linespec location (or *ADDRESS):
+//   - LINENUM: line in the default source file (see defaultLocation())
+//   - +OFFSET, -OFFSET: line relative to the current line
+//   - FILENAME:LINENUM
+//   - FUNCTION
+//   - FUNCTION:LABEL
+//   - FILENAME:FUNCTION
+//   - *ADDRESS
+//
+// Locations that need Delve to be resolved are resolved by resolveBreakpoint().
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Breakpoint-Commands.html
+// If pending is true, a location in a file that cannot be resolved
+// (see resolveSourceFile()) is still accepted.
+func (k *kabuta) newBreakpoint(rawLocation string, pending bool) (*breakpoint, error) {
+	bp := breakpoint{rawLocation: rawLocation, dlvBreakpoint: &api.Breakpoint{}, pending: pending}
+	if strings.HasPrefix(rawLocation, "*") {
+		addr, err := strconv.ParseUint(strings.TrimSpace(rawLocation[1:]), 0, 64)
+		if err != nil {
+			return nil, NewError("Cannot parse address in %s: %s", rawLocation, err)
+		}
+		bp.breakpointType = breakpointTypeAddress
+		bp.dlvBreakpoint.Addr = addr
+		return &bp, nil
+	}
+	elts := strings.Split(rawLocation, ":")
+	if len(elts) == 1 {
+		elt := elts[0]
+		if strings.HasPrefix(elt, "+") || strings.HasPrefix(elt, "-") {
+			offset, err := strconv.Atoi(elt)
+			if err != nil {
+				return nil, NewError("Cannot parse line offset in %s: %s", rawLocation, err)
+			}
+			fileName, lineNo, err := k.defaultLocation()
+			if err != nil {
+				return nil, err
+			}
+			bp.breakpointType = breakpointTypeOffset
+			bp.setFileLine(fileName, lineNo+offset)
+			return &bp, nil
+		}
+		lineNo, err := strconv.Atoi(elt)
+		if err == nil {
+			fileName, _, err := k.defaultLocation()
+			if err != nil {
+				return nil, err
+			}
+			bp.breakpointType = breakpointTypeLinenum
+			bp.setFileLine(fileName, lineNo)
+			return &bp, nil
+		}
+		// Assume it's function
+		bp.breakpointType = breakpointTypeFunction
+		bp.function = elts[0]
+		bp.dlvBreakpoint.FunctionName = elts[0]
+		return &bp, nil
+	}
+	if len(elts) != 2 {
+		return nil, NewError("Breakpoint location type not supported: %s", rawLocation)
+	}
+	lineNo, err := strconv.Atoi(elts[1])
+	if err != nil {
+		if strings.HasSuffix(elts[0], ".go") {
+			bp.breakpointType = breakpointTypeFilenameFunction
+			bp.fileName = elts[0]
+			resolved, err := k.resolveSourceFile(elts[0])
+			if err == nil {
+				bp.fileName = resolved
+			}
+			bp.function = elts[1]
+		} else {
+			bp.breakpointType = breakpointTypeFunctionLabel
+			bp.function = elts[0]
+			bp.label = elts[1]
+		}
+		return &bp, nil
+	}
+	fileName, err := k.resolveSourceFile(elts[0])
+	if err != nil {
+		if !pending {
+			return nil, err
+		}
+		// Delve may know better once it runs (see resolveBreakpoint()).
+		k.log("Keeping %s as is for pending breakpoint: %s", elts[0], err)
+		fileName = elts[0]
+	}
+	bp.breakpointType = breakpointTypeFilenameLinenum
+	bp.setFileLine(fileName, lineNo)
+	return &bp, nil
+}
+
+// newLabelBreakpoint creates a breakpoint at the label in the function of
+// the selected frame (as specified by the --label explicit location).
+func (k *kabuta) newLabelBreakpoint(label string, pending bool) (*breakpoint, error) {
+	bp := breakpoint{rawLocation: "-label " + label, dlvBreakpoint: &api.Breakpoint{}, pending: pending}
+	loc, err := k.selectedLocation()
+	if err != nil {
+		return nil, err
+	}
+	if loc.Function == nil {
+		return nil, NewError("No function contains the current location, cannot find label %s.", label)
+	}
+	bp.breakpointType = breakpointTypeLabel
+	bp.function = loc.Function.Name()
+	bp.label = label
+	return &bp, nil
+}
+
+// setFileLine sets the file and line of the breakpoint.
+func (bp *breakpoint) setFileLine(fileName string, lineNo int) {
+	bp.fileName = fileName
+	bp.lineNo = lineNo
+	bp.dlvBreakpoint.File = fileName
+	bp.dlvBreakpoint.Line = lineNo
+}
+
+// selectedLocation returns the location of the selected frame.
+func (k *kabuta) selectedLocation() (api.Location, error) {
+	if k.selectedFrame > 0 {
+		frame, err := k.selectedStackframe()
+		if err == nil {
+			return frame.Location, nil
+		}
+	}
+	if k.dlvState != nil {
+		loc, ok := stateLocation(k.dlvState)
+		if ok && loc.File != "" {
+			return loc, nil
+		}
+	}
+	return api.Location{}, NewError("No frame selected.")
+}
+
+// defaultLocation returns the file and line relative to which linespecs
+// without a file name are interpreted. As in GDB, this is the location at
+// which the target is stopped, or, if it has not been started, the file
+// containing main().
+func (k *kabuta) defaultLocation() (string, int, error) {
+	loc, err := k.selectedLocation()
+	if err == nil {
+		return loc.File, loc.Line, nil
+	}
+	if k.dlvRpcClient != nil {
+		locs, err := k.findLocation("main.main")
+		if err == nil && len(locs) > 0 {
+			return locs[0].File, locs[0].Line, nil
+		}
+	}
+	if k.debugBinaryPackageDir != "" {
+		fileName, lineNo, err := findMainFunc(k.debugBinaryPackageDir)
+		if err == nil {
+			return fileName, lineNo, nil
+		}
+		k.log("Cannot find main() in %s: %s", k.debugBinaryPackageDir, err)
+	}
+	return "", 0, NewError("No symbol table is loaded.  Use the \"file\" command.")
+}
+
+// findMainFunc looks for the declaration of main() in the Go files in dir,
+// returning the file and the line of the declaration.
+func findMainFunc(dir string) (string, int, error) {
+	fileNames, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", 0, err
+	}
+	for _, fileName := range fileNames {
+		lines, err := readLines(fileName)
+		if err != nil {
+			return "", 0, err
+		}
+		for i, line := range lines {
+			if strings.HasPrefix(line, "func main()") {
+				return fileName, i + 1, nil
+			}
+		}
+	}
+	return "", 0, NewError("No func main() found in %s", dir)
+}
+
+// findLocation asks Delve to find locations matching the location expression.
+// See https://github.com/go-delve/delve/blob/master/Documentation/cli/locspec.md
+func (k *kabuta) findLocation(locExpr string) ([]api.Location, error) {
+	in := rpc2.FindLocationIn{Scope: api.EvalScope{GoroutineID: -1}, Loc: locExpr}
+	out := rpc2.FindLocationOut{}
+	err := k.dlvRpcClient.Call("RPCServer.FindLocation", in, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Locations, nil
+}
+
+// resolveBreakpoint uses Delve to find out the file and line (or function)
+// of breakpoints whose locations Delve cannot handle itself, namely
+// FILENAME:FUNCTION and labels. The file of FILENAME:LINENUM is resolved
+// again, as it may have been kept as given for a pending breakpoint
+// until the sources Delve knows of could be searched.
+func (k *kabuta) resolveBreakpoint(bp *breakpoint) error {
+	switch bp.breakpointType {
+	case breakpointTypeFilenameLinenum:
+		fileName, err := k.resolveSourceFile(bp.fileName)
+		if err != nil {
+			return err
+		}
+		bp.setFileLine(fileName, bp.lineNo)
+	case breakpointTypeFilenameFunction:
+		// Function may not be qualified with the package, so
+		// look at all functions with that name.
+		locs, err := k.findLocation(f("/(^|\\.)%s$/", regexp.QuoteMeta(bp.function)))
+		if err != nil {
+			return NewError("Function \"%s\" not defined in \"%s\": %s", bp.function, bp.fileName, err)
+		}
+		var matches []api.Location
+		for _, loc := range locs {
+			if sourceMatches(loc.File, bp.fileName) && loc.Function != nil {
+				matches = append(matches, loc)
+			}
+		}
+		if len(matches) == 0 {
+			return NewError("Function \"%s\" not defined in \"%s\".", bp.function, bp.fileName)
+		}
+		if len(matches) > 1 {
+			return NewError("Location %s is ambiguous: it matches %d functions.", bp.rawLocation, len(matches))
+		}
+		bp.dlvBreakpoint.FunctionName = matches[0].Function.Name()
+	case breakpointTypeFunctionLabel, breakpointTypeLabel:
+		locs, err := k.findLocation(bp.function)
+		if err != nil {
+			return NewError("Function \"%s\" not defined: %s", bp.function, err)
+		}
+		if len(locs) == 0 {
+			return NewError("Function \"%s\" not defined.", bp.function)
+		}
+		fileName, lineNo, err := k.findLabel(locs[0].File, locs[0].Line, bp.label)
+		if err != nil {
+			return err
+		}
+		bp.setFileLine(fileName, lineNo)
+	}
+	return nil
+}
+
+// findLabel returns the first line with code at or after the label
+// in the function starting at funcLine. Delve does not know about
+// labels, so the source is scanned for the label.
+func (k *kabuta) findLabel(fileName string, funcLine int, label string) (string, int, error) {
+	lines, err := readLines(fileName)
+	if err != nil {
+		return "", 0, err
+	}
+	labelRegexp := regexp.MustCompile(f("^\\s*%s:", regexp.QuoteMeta(label)))
+	for i := funcLine; i < len(lines); i++ {
+		if i > funcLine && strings.HasPrefix(lines[i], "func ") {
+			break
+		}
+		if !labelRegexp.MatchString(lines[i]) {
+			continue
+		}
+		// The label itself may be on a line of its own,
+		// which Delve cannot set a breakpoint on. Lines are
+		// numbered from 1, so lines[lineNo-1] is line lineNo.
+		for lineNo := i + 1; lineNo <= len(lines); lineNo++ {
+			if lineNo > i+1 && strings.HasPrefix(lines[lineNo-1], "func ") {
+				break
+			}
+			locs, err := k.findLocation(f("%s:%d", fileName, lineNo))
+			if err == nil && len(locs) > 0 {
+				return fileName, lineNo, nil
+			}
+		}
+		return "", 0, NewError("No code after label \"%s\" at %s:%d.", label, fileName, i+1)
+	}
+	return "", 0, NewError("No label \"%s\" defined in function at %s:%d.", label, fileName, funcLine)
+}
+
+// disp returns the disposition of the breakpoint as GDB reports it.
+func (bp *breakpoint) disp() string {
+	if bp.temporary {
+		return "del"
+	}
+	return "keep"
+}
+
+// updateDlvBreakpoint updates the condition and the hit condition
+// of the Delve breakpoint based on the condition, ignore count and
+// goroutine restriction specified by the frontend.
+func (bp *breakpoint) updateDlvBreakpoint() {
+	cond := bp.condition
+	if bp.threadID > 0 {
+		threadCond := f("runtime.curg.goid == %d", bp.threadID)
+		if bp.osThread {
+			threadCond = f("runtime.threadid == %d", bp.threadID)
+		}
+		if cond == "" {
+			cond = threadCond
+		} else {
+			cond = f("(%s) && %s", cond, threadCond)
+		}
+	}
+	bp.dlvBreakpoint.Cond = cond
+	bp.dlvBreakpoint.HitCond = ""
+	if bp.ignoreCount > 0 {
+		bp.dlvBreakpoint.HitCond = f("> %d", bp.ignoreBase+uint64(bp.ignoreCount))
+	}
+}
+
+// dlvHitCount returns how many times the breakpoint has been hit
+// according to Delve, 0 if it is not in Delve.
+func (k *kabuta) dlvHitCount(bp *breakpoint) (uint64, error) {
+	if !k.inDlv(bp) {
+		return 0, nil
+	}
+	out := rpc2.GetBreakpointOut{}
+	err := k.dlvRpcClient.Call("RPCServer.GetBreakpoint", rpc2.GetBreakpointIn{Id: bp.dlvBreakpoint.ID}, &out)
+	if err != nil {
+		return 0, NewError("Error getting breakpoint %d: %s", bp.number, err)
+	}
+	return out.Breakpoint.TotalHitCount, nil
+}
+
+// createDlvBreakpoint creates the breakpoint in Delve and
+// replaces dlvBreakpoint with what Delve has actually set.
+func (k *kabuta) createDlvBreakpoint(bp *breakpoint) error {
+	if bp.breakpointType == breakpointTypeCatchpoint {
+		return k.attachCatchpoint(bp)
+	}
+	// The new Delve breakpoint has not been hit yet.
+	if bp.ignoreBase > 0 {
+		bp.ignoreBase = 0
+		bp.updateDlvBreakpoint()
+	}
+	err := k.resolveBreakpoint(bp)
+	if err != nil {
+		if bp.pending {
+			k.log("Leaving breakpoint %d pending: %s", bp.number, err)
+			return nil
+		}
+		return err
+	}
+	bpIn := rpc2.CreateBreakpointIn{Breakpoint: *bp.dlvBreakpoint, Suspended: bp.pending}
+	bpOut := &rpc2.CreateBreakpointOut{}
+	err = k.dlvRpcClient.Call("RPCServer.CreateBreakpoint", bpIn, bpOut)
+	if err != nil {
+		return NewError("Error setting breakpoint %s: %s", bp, err)
+	}
+	bp.dlvBreakpoint = &bpOut.Breakpoint
+	k.log("Set breakpoint %v", bp.dlvBreakpoint)
+	return nil
+}
+
+// breakpointByDlvID returns the breakpoint corresponding to the
+// Delve breakpoint with the given ID, or nil if there is no such breakpoint.
+func (k *kabuta) breakpointByDlvID(dlvID int) *breakpoint {
+	for _, bp := range k.breakpoints {
+		if bp.dlvBreakpoint.ID == dlvID {
+			return bp
+		}
+	}
+	return nil
+}
+
+// addBreakpoint assigns the next breakpoint number to the breakpoint
+// and stores it.
+func (k *kabuta) addBreakpoint(bp *breakpoint) {
+	k.lastBreakpointNo++
+	bp.number = k.lastBreakpointNo
+	k.breakpoints[bp.number] = bp
+}
+
+// sortedBreakpoints returns the breakpoints ordered by their numbers.
+func (k *kabuta) sortedBreakpoints() []*breakpoint {
+	numbers := make([]int, 0, len(k.breakpoints))
+	for bpNo := range k.breakpoints {
+		numbers = append(numbers, bpNo)
+	}
+	sort.Ints(numbers)
+	bps := make([]*breakpoint, len(numbers))
+	for i, bpNo := range numbers {
+		bps[i] = k.breakpoints[bpNo]
+	}
+	return bps
+}
+
+// inDlv returns true if the breakpoint has been created in Delve.
+// Breakpoints inserted before Delve is launched are created
+// by ExecRun, so changes to them until then need not be sent to Delve.
+func (k *kabuta) inDlv(bp *breakpoint) bool {
+	return k.dlvRpcClient != nil && bp.dlvBreakpoint.ID != 0
+}
+
+// deleteBreakpoint clears the breakpoint in Delve (if it is there)
+// and forgets about it.
+func (k *kabuta) deleteBreakpoint(bp *breakpoint) error {
+	// Delve's own breakpoints backing catchpoints stay.
+	if bp.breakpointType == breakpointTypeCatchpoint {
+		err := k.detachCatchpoint(bp)
+		if err != nil {
+			return err
+		}
+	} else if k.inDlv(bp) {
+		out := rpc2.ClearBreakpointOut{}
+		err := k.dlvRpcClient.Call("RPCServer.ClearBreakpoint", rpc2.ClearBreakpointIn{Id: bp.dlvBreakpoint.ID}, &out)
+		if err != nil {
+			return NewError("Error deleting breakpoint %d: %s", bp.number, err)
+		}
+	}
+	delete(k.breakpoints, bp.number)
+	return nil
+}
+
+// amendBreakpoint sends the changes made to dlvBreakpoint (enabling,
+// condition, etc.) to Delve, if the breakpoint is there.
+func (k *kabuta) amendBreakpoint(bp *breakpoint) error {
+	if !k.inDlv(bp) {
+		return nil
+	}
+	out := rpc2.AmendBreakpointOut{}
+	err := k.dlvRpcClient.Call("RPCServer.AmendBreakpoint", rpc2.AmendBreakpointIn{Breakpoint: *bp.dlvBreakpoint}, &out)
+	if err != nil {
+		return NewError("Error modifying breakpoint %d: %s", bp.number, err)
+	}
+	return nil
+}
+
+// refreshBreakpoints gets the breakpoints from Delve, so that
+// hit counts are current.
+func (k *kabuta) refreshBreakpoints() error {
+	if k.dlvRpcClient == nil || k.isRunning() {
+		return nil
+	}
+	out := rpc2.ListBreakpointsOut{}
+	err := k.dlvRpcClient.Call("RPCServer.ListBreakpoints", rpc2.ListBreakpointsIn{}, &out)
+	if err != nil {
+		return NewError("Error listing breakpoints: %s", err)
+	}
+	for _, dlvBp := range out.Breakpoints {
+		bp := k.breakpointByDlvID(dlvBp.ID)
+		if bp != nil {
+			bp.dlvBreakpoint = dlvBp
+		}
+	}
+	return nil
+}
+
+// deleteTemporaryBreakpoint deletes the breakpoint just hit if it was
+// inserted with -t. It returns true if it was deleted, in which case
+// the caller is to notify the frontend about it.
+func (k *kabuta) deleteTemporaryBreakpoint(bp *breakpoint) bool {
+	if !bp.temporary {
+		return false
+	}
+	err := k.deleteBreakpoint(bp)
+	if err != nil {
+		k.log("Error deleting temporary breakpoint: %s", err)
+		return false
+	}
+	return true
+}
+
+// miBreakpoint formats the breakpoint as GDB/MI breakpoint
+// information, e.g.:
+// bkpt={number="1",type="breakpoint",disp="keep",enabled="y",addr="0x000000000049a4c2",func="main.main",...}
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Breakpoint-Information.html
+func (k *kabuta) miBreakpoint(bp *breakpoint) string {
+	dlvBp := bp.dlvBreakpoint
+	bpType := "breakpoint"
+	if bp.dprintfFormat != "" {
+		bpType = "dprintf"
+	} else if dlvBp.Tracepoint {
+		bpType = "tracepoint"
+	}
+	enabled := "y"
+	if dlvBp.Disabled {
+		enabled = "n"
+	}
+	if bp.breakpointType == breakpointTypeWatchpoint {
+		bpType = bp.watchpointType()
+	} else if bp.breakpointType == breakpointTypeCatchpoint {
+		bpType = "catchpoint"
+	}
+	result := f("number=\"%d\",type=\"%s\",disp=\"%s\",enabled=\"%s\",", bp.number, bpType, bp.disp(), enabled)
+	if bp.breakpointType == breakpointTypeCatchpoint {
+		what := "unrecovered panic"
+		if bp.catchType == catchTypeThrow {
+			what = "fatal runtime error"
+		}
+		result += f("what=\"%s\",catch-type=\"%s\",", what, bp.catchType)
+	} else if bp.breakpointType == breakpointTypeWatchpoint {
+		result += f("addr=\"\",what=%s,", miQuote(bp.rawLocation))
+	} else if dlvBp.Addr == 0 {
+		result += f("addr=\"<PENDING>\",pending=%s,", miQuote(bp.rawLocation))
+	} else {
+		result += f("addr=\"0x%016x\",func=%s,", dlvBp.Addr, miQuote(dlvBp.FunctionName))
+		result += f("file=%s,fullname=%s,line=\"%d\",", miQuote(filepath.Base(dlvBp.File)), miQuote(dlvBp.File), dlvBp.Line)
+	}
+	result += "thread-groups=[\"i1\"],"
+	if bp.threadID > 0 {
+		result += f("thread=\"%d\",", bp.threadID)
+	}
+	if bp.condition != "" {
+		result += f("cond=%s,", miQuote(bp.condition))
+	}
+	if bp.ignoreCount > 0 {
+		result += f("ignore=\"%d\",", bp.ignoreCount)
+	}
+	if len(bp.commands) > 0 {
+		script := make([]string, len(bp.commands))
+		for i, cmd := range bp.commands {
+			script[i] = miQuote(cmd)
+		}
+		result += f("script={%s},", strings.Join(script, ","))
+	}
+	result += f("times=\"%d\",original-location=%s", dlvBp.TotalHitCount, miQuote(bp.rawLocation))
+	return "bkpt={" + result + "}"
+}
+
+// resumeCommands are commands that resume the target. Like in GDB, breakpoint
+// commands following one of them are not run.
+var resumeCommands = map[string]bool{
+	"continue": true,
+	"next":     true,
+	"step":     true,
+	"finish":   true,
+	"nexti":    true,
+	"stepi":    true,
+}
+
+// runBreakpointCommands queues the commands of the breakpoint which
+// has stopped the target to be processed as if they came from the frontend,
+// except their output goes to the console. If they resume the target,
+// the remaining commands are not run.
+func (k *kabuta) runBreakpointCommands(commands []string) {
+	for _, cmd := range commands {
+		cmd = strings.TrimSpace(cmd)
+		// There is no stop printing to silence.
+		if cmd == "silent" || cmd == "" {
+			continue
+		}
+		req := newFrontendRequest(k, cmd)
+		req.console = true
+		k.internalChannel <- req
+		// Resuming commands may take arguments, as in continue 3.
+		if resumeCommands[strings.Fields(cmd)[0]] || strings.HasPrefix(cmd, "-exec-") {
+			break
+		}
+	}
+}
+
+// miBreakpointTableHeader is the hdr part of the BreakpointTable
+// returned by break-list, as GDB returns it.
+const miBreakpointTableHeader = `hdr=[{width="7",alignment="-1",col_name="number",colhdr="Num"},` +
+	`{width="14",alignment="-1",col_name="type",colhdr="Type"},` +
+	`{width="4",alignment="-1",col_name="disp",colhdr="Disp"},` +
+	`{width="3",alignment="-1",col_name="enabled",colhdr="Enb"},` +
+	`{width="18",alignment="-1",col_name="addr",colhdr="Address"},` +
+	`{width="40",alignment="2",col_name="what",colhdr="What"}]`
@@ -0,0 +1,194 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"reflect"
+)
+
+const (
+	// Names of breakpoints Delve sets by itself on unrecovered
+	// panics and fatal runtime errors.
+	dlvUnrecoveredPanic = "unrecovered-panic"
+	dlvFatalThrow       = "runtime-fatal-throw"
+
+	catchTypePanic = "panic"
+	catchTypeThrow = "throw"
+)
+
+// Catch is invoked in response to the catch CLI command. Supported are:
+//   - catch panic: stop when a panic is not recovered
+//   - catch throw: stop on fatal runtime errors (runtime.throw)
+func (c *gdbCmd) Catch() gdbMiResponse {
+	if len(c.args) == 0 {
+		return returnErrorf("Catch requires an event name: panic or throw.")
+	}
+	event := c.args[0]
+	c.args = c.args[1:]
+	switch event {
+	case catchTypePanic:
+		return c.catch(catchTypePanic)
+	case catchTypeThrow:
+		return c.catch(catchTypeThrow)
+	default:
+		return returnErrorf("Undefined catch command: \"%s\".  Try \"help catch\".", c.argsStr)
+	}
+}
+
+// CatchPanic is invoked in response to catch-panic GDB MI command
+// (the MI version of catch panic).
+func (c *gdbCmd) CatchPanic() gdbMiResponse {
+	return c.catch(catchTypePanic)
+}
+
+// CatchThrow is invoked in response to catch-throw GDB MI command
+// (the MI version of catch throw). In Go, throw is a fatal runtime error.
+func (c *gdbCmd) CatchThrow() gdbMiResponse {
+	return c.catch(catchTypeThrow)
+}
+
+// catch creates a catchpoint for the catch type, which maps
+// to one of the breakpoints Delve sets by itself.
+func (c *gdbCmd) catch(catchType string) gdbMiResponse {
+	temporary := c.flagSet.Bool("t", false, "")
+	filter := c.flagSet.String("r", "", "")
+	err := c.flagSet.Parse(c.args)
+	if err != nil {
+		return returnErrorf("Error parsing arguments %s: %s", c.argsStr, err)
+	}
+	if *filter != "" {
+		return returnErrorf("Filtering %s catchpoints with a regular expression is not supported.", catchType)
+	}
+	bp := &breakpoint{
+		rawLocation:    catchType,
+		breakpointType: breakpointTypeCatchpoint,
+		catchType:      catchType,
+		temporary:      *temporary,
+		dlvBreakpoint:  &api.Breakpoint{Name: dlvUnrecoveredPanic},
+	}
+	if catchType == catchTypeThrow {
+		bp.dlvBreakpoint.Name = dlvFatalThrow
+	}
+	return c.insertBreakpoint(bp)
+}
+
+// attachCatchpoint makes the catchpoint refer to the breakpoint Delve
+// has set by itself, making sure it is enabled.
+func (k *kabuta) attachCatchpoint(bp *breakpoint) error {
+	out := rpc2.GetBreakpointOut{}
+	err := k.dlvRpcClient.Call("RPCServer.GetBreakpoint", rpc2.GetBreakpointIn{Name: bp.dlvBreakpoint.Name}, &out)
+	if err != nil {
+		return NewError("Cannot catch %s: %s", bp.catchType, err)
+	}
+	disabled := bp.dlvBreakpoint.Disabled
+	bp.dlvBreakpoint = &out.Breakpoint
+	if bp.dlvBreakpoint.Disabled != disabled {
+		bp.dlvBreakpoint.Disabled = disabled
+		return k.amendBreakpoint(bp)
+	}
+	return nil
+}
+
+// detachCatchpoint gives the Delve breakpoint the catchpoint refers to
+// back to the other catchpoint of the same type, if there is one, or
+// otherwise restores it to how Delve set it: enabled and unconditional.
+func (k *kabuta) detachCatchpoint(bp *breakpoint) error {
+	if !k.inDlv(bp) {
+		return nil
+	}
+	for _, other := range k.sortedBreakpoints() {
+		if other != bp && other.breakpointType == breakpointTypeCatchpoint && other.catchType == bp.catchType {
+			return k.amendBreakpoint(other)
+		}
+	}
+	bp.dlvBreakpoint.Disabled = false
+	bp.dlvBreakpoint.Cond = ""
+	bp.dlvBreakpoint.HitCond = ""
+	return k.amendBreakpoint(bp)
+}
+
+// catchpointResults creates the part of *stopped record results for
+// the target stopped at one of Delve's panic and fatal error breakpoints,
+// or returns "" if that is not the case. An unrecovered panic is reported
+// as an exception caught, and a fatal error as a signal (as the process is
+// about to abort), both with the panic value or error message, e.g.:
+// reason="exception-caught",bkptno="2",exception="index out of range [5] with length 3",
+func (k *kabuta) catchpointResults(thread *api.Thread) string {
+	dlvBp := thread.Breakpoint
+	var results string
+	switch dlvBp.Name {
+	case dlvUnrecoveredPanic:
+		results = "reason=\"exception-caught\","
+	case dlvFatalThrow:
+		results = "reason=\"signal-received\",signal-name=\"SIGABRT\",signal-meaning=\"Aborted\","
+	default:
+		return ""
+	}
+	bp := k.breakpointByDlvID(dlvBp.ID)
+	if bp != nil {
+		results += f("disp=\"%s\",bkptno=\"%d\",", bp.disp(), bp.number)
+	}
+	value, ok, err := k.catchpointValue(thread)
+	if err != nil {
+		k.log("Cannot get value for %s: %s", dlvBp.Name, err)
+	} else if ok {
+		results += f("exception=%s,", miQuote(value))
+	}
+	return results
+}
+
+// throwFunctions are the functions whose argument s is the message of
+// a fatal error. Depending on the version of Delve, its breakpoint is in
+// them or in the runtime.fatalthrow() they call, which takes no message.
+var throwFunctions = map[string]bool{
+	"runtime.throw": true,
+	"runtime.fatal": true,
+}
+
+// maxThrowDepth is the number of frames searched for one of throwFunctions.
+const maxThrowDepth = 16
+
+// catchpointValue returns the panic value or fatal error message at
+// a stop at one of Delve's panic and fatal error breakpoints. It returns
+// false if there is none, which is the case if the fatal error was not
+// raised by one of throwFunctions.
+func (k *kabuta) catchpointValue(thread *api.Thread) (string, bool, error) {
+	var v *api.Variable
+	if thread.BreakpointInfo != nil && len(thread.BreakpointInfo.Variables) > 0 {
+		// Delve evaluates runtime.curg._panic.arg for unrecovered-panic.
+		v = &thread.BreakpointInfo.Variables[0]
+	} else {
+		expr := "runtime.curg._panic.arg"
+		scope := api.EvalScope{GoroutineID: thread.GoroutineID}
+		if thread.Breakpoint.Name == dlvFatalThrow {
+			frames, err := k.stacktrace(thread.GoroutineID, maxThrowDepth)
+			if err != nil {
+				return "", false, err
+			}
+			scope.Frame = -1
+			for i, frame := range frames {
+				if throwFunctions[functionName(frame.Location)] {
+					scope.Frame = i
+					break
+				}
+			}
+			if scope.Frame < 0 {
+				return "", false, nil
+			}
+			expr = "s"
+		}
+		var err error
+		v, err = k.eval(expr, scope)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	// The panic value is an interface; show what's in it.
+	if v.Kind == reflect.Interface && len(v.Children) > 0 {
+		v = &v.Children[0]
+	}
+	if v.Kind == reflect.String {
+		return v.Value, true, nil
+	}
+	return v.SinglelineString(), true, nil
+}
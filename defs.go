@@ -9,16 +9,30 @@ const (
 	EnvKabutaDlvPath = "KABUTA_DLV_PATH"
 	EnvKabutaDlvPort = "KABUTA_DLV_PORT"
 	EnvKabutaPath    = "KABUTA_PATH"
+	// What MI threads are: goroutines (ThreadModelGoroutines, the default)
+	// or OS threads (ThreadModelThreads). Can be changed with
+	// -gdb-set kabuta thread-model.
+	EnvKabutaThreadModel  = "KABUTA_THREAD_MODEL"
+	ThreadModelGoroutines = "goroutines"
+	ThreadModelThreads    = "threads"
+	// Whether goroutines that Delve considers system (runtime) ones are
+	// listed: on (the default) or off. Can be changed with
+	// -gdb-set kabuta show-system-goroutines.
+	EnvKabutaShowSystemGoroutines = "KABUTA_SHOW_SYSTEM_GOROUTINES"
+	// Regular expression for goroutines not to list, matched against
+	// their start and current locations, given as "function file:line".
+	EnvKabutaHiddenGoroutines = "KABUTA_HIDDEN_GOROUTINES"
 	// Init file, looked for in user's home directory, that can override environment
 	// variables.
 	KabutaInitFile        = ".kabutainit"
 	DefaultKabutaLogFile  = "kabuta.log"
 	DefaultDlvPort        = "8181"
 	DlvVersionOutputStart = "Delve Debugger"
-	// MI2 command. First group is token (to include in response).
-	RegexpMiCmd = "([0-9]+)-(.*)"
+	// MI2 command. First group is token (to include in response), which
+	// may be empty (e.g., for breakpoint commands).
+	RegexpMiCmd = "^([0-9]*)-(.*)$"
 	// GDB CLI command. First group is token (to include in response).
-	RegexpCliCmd = "([0-9]+)(.*)"
+	RegexpCliCmd = "^([0-9]*)(.*)$"
 
 	GdbPrompt = "(gdb)\n"
 
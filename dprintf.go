@@ -0,0 +1,121 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DprintfInsert is invoked in response to dprintf-insert GDB MI command.
+// The dynamic printf is a Delve tracepoint that evaluates the arguments;
+// when it is hit, kabuta.execute() formats them as specified and sends
+// the result to the console, then resumes the target.
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Breakpoint-Commands.html
+func (c *gdbCmd) DprintfInsert() gdbMiResponse {
+	//	-dprintf-insert [ -t ] [ -f ] [ -d ]
+	//	   [ -c condition ] [ -i ignore-count ]
+	//	   [ -p thread-id ] [ location ] [ format ]
+	//	   [ argument ]
+	bp, args, resp := c.parseBreakpoint()
+	if bp == nil {
+		return resp
+	}
+	if len(args) == 0 {
+		return returnErrorf("Format string required")
+	}
+	bp.dprintfFormat = args[0]
+	bp.dlvBreakpoint.Tracepoint = true
+	bp.dlvBreakpoint.Variables = args[1:]
+	return c.insertBreakpoint(bp)
+}
+
+// dprintf formats and sends to the console the output of the dynamic printf
+// breakpoint hit by the thread.
+func (k *kabuta) dprintf(bp *breakpoint, thread *api.Thread) {
+	var values []api.Variable
+	if thread.BreakpointInfo != nil {
+		values = thread.BreakpointInfo.Variables
+	}
+	k.sendConsoleStreamRecord("%s", formatDprintf(bp.dprintfFormat, values))
+}
+
+// formatDprintf formats the values according to the printf-style format,
+// the way GDB's dprintf would. As values come from Delve already rendered,
+// conversions only affect how numbers are shown.
+func formatDprintf(format string, values []api.Variable) string {
+	var b strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			b.WriteByte('%')
+			i++
+			continue
+		}
+		// Flags and width are kept, length modifiers are not
+		// meaningful for Go's fmt.
+		spec := "%"
+		j := i + 1
+		for ; j < len(format) && strings.IndexByte("-+ #0123456789.", format[j]) >= 0; j++ {
+			spec += string(format[j])
+		}
+		for ; j < len(format) && strings.IndexByte("hlLqjzt", format[j]) >= 0; j++ {
+		}
+		if j == len(format) {
+			b.WriteString(format[i:])
+			break
+		}
+		verb := format[j]
+		i = j
+		if next >= len(values) {
+			b.WriteString("<missing>")
+			continue
+		}
+		b.WriteString(formatDprintfValue(spec, verb, &values[next]))
+		next++
+	}
+	return b.String()
+}
+
+// formatDprintfValue formats a single value for formatDprintf.
+func formatDprintfValue(spec string, verb byte, v *api.Variable) string {
+	if v.Unreadable != "" {
+		return "<" + v.Unreadable + ">"
+	}
+	switch verb {
+	case 'd', 'i', 'u', 'x', 'X', 'o', 'c':
+		goVerb := string(verb)
+		if verb == 'i' || verb == 'u' {
+			goVerb = "d"
+		}
+		if n, err := strconv.ParseInt(v.Value, 0, 64); err == nil {
+			if n < 0 && (verb == 'u' || verb == 'x' || verb == 'X' || verb == 'o') {
+				// As in C, the value is taken as unsigned of the same size.
+				return f(spec+goVerb, twosComplement(n, integerBits(v)))
+			}
+			return f(spec+goVerb, n)
+		}
+		if n, err := strconv.ParseUint(v.Value, 0, 64); err == nil {
+			return f(spec+goVerb, n)
+		}
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		if x, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return f(spec+strings.ToLower(string(verb)), x)
+		}
+	case 'p':
+		addr := v.Addr
+		if v.Kind == reflect.Ptr && len(v.Children) > 0 {
+			addr = v.Children[0].Addr
+		}
+		return f(spec+"s", f("0x%x", addr))
+	case 's':
+		if v.Kind == reflect.String {
+			return f(spec+"s", v.Value)
+		}
+	}
+	return f(spec+"s", v.SinglelineString())
+}
@@ -0,0 +1,75 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+)
+
+// evalScope returns the scope in which expressions are evaluated:
+// the selected frame of the selected goroutine (initially, the innermost
+// frame of the goroutine that the target stopped in). Without a selected
+// goroutine, this is Delve's current thread (see selectThread()).
+func (k *kabuta) evalScope() api.EvalScope {
+	scope := api.EvalScope{GoroutineID: -1, Frame: k.selectedFrame}
+	if k.selectedGoroutineID > 0 {
+		scope.GoroutineID = k.selectedGoroutineID
+	}
+	return scope
+}
+
+// eval evaluates the Go expression in the scope using Delve.
+func (k *kabuta) eval(expr string, scope api.EvalScope) (*api.Variable, error) {
+	return k.evalWithConfig(expr, scope, *k.loadConfig)
+}
+
+// evalWithConfig evaluates the Go expression in the scope using Delve,
+// loading as much of the value as cfg allows.
+func (k *kabuta) evalWithConfig(expr string, scope api.EvalScope, cfg api.LoadConfig) (*api.Variable, error) {
+	if k.dlvRpcClient == nil {
+		return nil, NewError("The program is not being run.")
+	}
+	out := rpc2.EvalOut{}
+	err := k.dlvRpcClient.Call("RPCServer.Eval", rpc2.EvalIn{Scope: scope, Expr: expr, Cfg: &cfg}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Variable, nil
+}
+
+// setVariable assigns the value (a Go expression) to the variable
+// denoted by the symbol (also a Go expression, e.g., s.users[3].Name)
+// in the scope using Delve, which refuses what it cannot assign.
+func (k *kabuta) setVariable(symbol string, value string, scope api.EvalScope) error {
+	if k.dlvRpcClient == nil {
+		return NewError("The program is not being run.")
+	}
+	out := rpc2.SetOut{}
+	return k.dlvRpcClient.Call("RPCServer.Set", rpc2.SetIn{Scope: scope, Symbol: symbol, Value: value}, &out)
+}
+
+// localVars returns the local variables visible in the scope.
+func (k *kabuta) localVars(scope api.EvalScope) ([]api.Variable, error) {
+	if k.dlvRpcClient == nil {
+		return nil, NewError("No frame selected.")
+	}
+	out := rpc2.ListLocalVarsOut{}
+	err := k.dlvRpcClient.Call("RPCServer.ListLocalVars", rpc2.ListLocalVarsIn{Scope: scope, Cfg: *k.loadConfig}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Variables, nil
+}
+
+// functionArgs returns the arguments (including named return values)
+// of the function of the scope's frame.
+func (k *kabuta) functionArgs(scope api.EvalScope) ([]api.Variable, error) {
+	if k.dlvRpcClient == nil {
+		return nil, NewError("No frame selected.")
+	}
+	out := rpc2.ListFunctionArgsOut{}
+	err := k.dlvRpcClient.Call("RPCServer.ListFunctionArgs", rpc2.ListFunctionArgsIn{Scope: scope, Cfg: *k.loadConfig}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Args, nil
+}
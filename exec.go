@@ -0,0 +1,322 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"path/filepath"
+	"strings"
+)
+
+// startExecution marks the target as running. It returns false if
+// it is already running, in which case the caller should not resume it.
+func (k *kabuta) startExecution() bool {
+	k.execLock.Lock()
+	defer k.execLock.Unlock()
+	if k.running {
+		return false
+	}
+	k.running = true
+	return true
+}
+
+// recordStop records the state Delve reported once the target has
+// stopped, selecting the innermost frame of the goroutine that stopped.
+// It returns true if the target was being interrupted (see interrupt()),
+// which is what stopped it unless it stopped by itself (see
+// stoppedByTarget()). The target is still considered running until
+// finishExecution() is called.
+func (k *kabuta) recordStop(state *api.DebuggerState) bool {
+	k.execLock.Lock()
+	defer k.execLock.Unlock()
+	k.dlvState = state
+	k.resetSelection()
+	return k.interrupted
+}
+
+// finishExecution marks the target as stopped, after which
+// frontendWriteLoop processes all commands again.
+func (k *kabuta) finishExecution() {
+	k.execLock.Lock()
+	defer k.execLock.Unlock()
+	k.running = false
+	k.interrupted = false
+}
+
+// resetSelection selects the innermost frame of the goroutine
+// (and the OS thread) that the target stopped in.
+func (k *kabuta) resetSelection() {
+	k.selectedGoroutineID = 0
+	k.selectedThreadID = 0
+	k.selectedFrame = 0
+	k.selectionGeneration++
+	if k.dlvState != nil {
+		k.selectedGoroutineID = stateGoroutineID(k.dlvState)
+		if k.dlvState.CurrentThread != nil {
+			k.selectedThreadID = int64(k.dlvState.CurrentThread.ID)
+		}
+	}
+}
+
+// isRunning returns true if the target is running.
+func (k *kabuta) isRunning() bool {
+	k.execLock.Lock()
+	defer k.execLock.Unlock()
+	return k.running
+}
+
+// interrupt records that the target is being interrupted by
+// the user, so that the stop is reported accordingly. It returns
+// false if the target is not running.
+func (k *kabuta) interrupt() bool {
+	k.execLock.Lock()
+	defer k.execLock.Unlock()
+	if !k.running {
+		return false
+	}
+	k.interrupted = true
+	return true
+}
+
+// execute issues the given Delve command (see api.DebuggerCommand) and
+// waits for the target to stop, after which it reports the stop to the frontend
+// as a *stopped async record. It is meant to be run on its own goroutine
+// (see gdbCmd.process()), so that frontendWriteLoop can keep processing commands
+// while the target is running.
+func (k *kabuta) execute(dlvCommand string) {
+	k.log("execute(): Sending %s to Delve", dlvCommand)
+	// ReturnInfoLoadConfig makes Delve load the return values after stepOut.
+	dlvCmd := api.DebuggerCommand{Name: dlvCommand, ReturnInfoLoadConfig: k.loadConfig}
+	var state *api.DebuggerState
+	for {
+		cmdOut := rpc2.CommandOut{}
+		err := k.dlvRpcClient.Call("RPCServer.Command", dlvCmd, &cmdOut)
+		if err != nil {
+			k.log("execute(): Error executing %s: %s", dlvCmd.Name, err)
+			k.sendConsoleStreamRecord("%s\n", err)
+			// Whatever happened, the frontend needs to know where we are now.
+			stateOut := rpc2.StateOut{}
+			err = k.dlvRpcClient.Call("RPCServer.State", rpc2.StateIn{}, &stateOut)
+			if err != nil || stateOut.State == nil {
+				k.log("execute(): Error getting state after %s: %v", dlvCmd.Name, err)
+				k.recordStop(nil)
+				k.finishExecution()
+				k.sendAsyncRecord("stopped", "")
+				return
+			}
+			cmdOut.State = *stateOut.State
+		}
+		state = &cmdOut.State
+		k.log("execute(): %s done, state: %s", dlvCmd.Name, String(state))
+		if !k.tracepointHit(state) {
+			break
+		}
+		// Tracepoints do not stop the target as far as
+		// the frontend is concerned. If a step was in progress,
+		// continuing completes it.
+		dlvCmd.Name = api.Continue
+	}
+	// Listing the goroutines on every step would make stepping
+	// slow in programs with many of them.
+	if state.Exited || !steppingCommands[dlvCommand] {
+		k.notifyThreadChanges(state.Exited)
+	}
+	// Once the target is marked as stopped, frontendWriteLoop goes on
+	// to commands that use the breakpoints, so everything involving
+	// them is done before.
+	var results string
+	var commands []string
+	var deleted *breakpoint
+	if k.recordStop(state) && !stoppedByTarget(state) {
+		results = k.interruptedResults(state)
+	} else {
+		results = k.stoppedResults(state, dlvCommand)
+		if state.CurrentThread != nil && state.CurrentThread.Breakpoint != nil {
+			bp := k.breakpointByDlvID(state.CurrentThread.Breakpoint.ID)
+			if bp != nil {
+				commands = bp.commands
+				if k.deleteTemporaryBreakpoint(bp) {
+					deleted = bp
+				}
+			}
+		}
+	}
+	k.finishExecution()
+	k.sendAsyncRecord("stopped", results)
+	if deleted != nil {
+		k.sendNotifyRecord("breakpoint-deleted", f("id=\"%d\"", deleted.number))
+	}
+	k.runBreakpointCommands(commands)
+}
+
+// steppingCommands are the Delve commands that step through the code.
+var steppingCommands = map[string]bool{
+	api.Next:            true,
+	api.Step:            true,
+	api.StepOut:         true,
+	api.StepInstruction: true,
+	api.NextInstruction: true,
+}
+
+// tracepointHit returns true if the target stopped only because of a
+// tracepoint (such as dynamic printf), after handling the tracepoint.
+// Once the target is being interrupted, tracepoints are not handled.
+func (k *kabuta) tracepointHit(state *api.DebuggerState) bool {
+	thread := state.CurrentThread
+	if state.Exited || thread == nil || thread.Breakpoint == nil || !thread.Breakpoint.Tracepoint {
+		return false
+	}
+	k.execLock.Lock()
+	interrupted := k.interrupted
+	k.execLock.Unlock()
+	if interrupted {
+		return false
+	}
+	bp := k.breakpointByDlvID(thread.Breakpoint.ID)
+	if bp != nil && bp.dprintfFormat != "" {
+		k.dprintf(bp, thread)
+	}
+	if bp != nil && k.deleteTemporaryBreakpoint(bp) {
+		k.sendNotifyRecord("breakpoint-deleted", f("id=\"%d\"", bp.number))
+	}
+	return true
+}
+
+// stoppedByTarget returns true if the target stopped by itself: it hit
+// a breakpoint (other than a tracepoint), a watchpoint went out of scope,
+// or it exited. Such a stop is reported as is, even if the target was
+// being interrupted at the same time.
+func stoppedByTarget(state *api.DebuggerState) bool {
+	if state.Exited || len(state.WatchOutOfScope) > 0 {
+		return true
+	}
+	thread := state.CurrentThread
+	return thread != nil && thread.Breakpoint != nil && !thread.Breakpoint.Tracepoint
+}
+
+// interruptedResults creates the results of *stopped async record
+// for the target stopped by exec-interrupt. Like GDB, it is reported
+// as SIGINT received by the selected goroutine.
+func (k *kabuta) interruptedResults(state *api.DebuggerState) string {
+	results := "reason=\"signal-received\",signal-name=\"SIGINT\",signal-meaning=\"Interrupt\","
+	loc, ok := stateLocation(state)
+	if ok {
+		results += f("frame={%s},", miFrame(loc))
+	}
+	results += f("thread-id=\"%d\",stopped-threads=\"all\"", k.stateThreadID(state))
+	return results
+}
+
+// stoppedResults creates the results of *stopped async record
+// describing the state Delve returned, e.g.:
+// reason="breakpoint-hit",disp="keep",bkptno="1",frame={...},thread-id="1",stopped-threads="all"
+// The reason depends on dlvCommand that caused the target to run.
+func (k *kabuta) stoppedResults(state *api.DebuggerState, dlvCommand string) string {
+	if state.Exited {
+		if state.ExitStatus == 0 {
+			return "reason=\"exited-normally\""
+		}
+		return f("reason=\"exited\",exit-code=\"%02o\"", state.ExitStatus)
+	}
+	results := k.watchpointScopeResults(state)
+	thread := state.CurrentThread
+	if results == "" && thread != nil && thread.Breakpoint != nil {
+		results = k.catchpointResults(thread)
+	}
+	if results == "" && thread != nil && thread.Breakpoint != nil {
+		bp := k.breakpointByDlvID(thread.Breakpoint.ID)
+		if bp != nil && bp.breakpointType == breakpointTypeWatchpoint {
+			results += k.watchpointTriggerResults(bp)
+		} else if bp != nil {
+			results += f("reason=\"breakpoint-hit\",disp=\"%s\",bkptno=\"%d\",", bp.disp(), bp.number)
+		}
+	}
+	if results == "" {
+		switch dlvCommand {
+		case api.StepOut:
+			results += "reason=\"function-finished\","
+		case api.Next, api.Step, api.StepInstruction, api.NextInstruction:
+			results += "reason=\"end-stepping-range\","
+		}
+	}
+	loc, ok := stateLocation(state)
+	if ok {
+		results += f("frame={%s},", miFrame(loc))
+	}
+	if dlvCommand == api.StepOut && thread != nil && len(thread.ReturnValues) > 0 {
+		k.resultVarNo++
+		results += f("gdb-result-var=\"$%d\",return-value=%s,", k.resultVarNo, miQuote(returnValue(thread.ReturnValues)))
+	}
+	results += f("thread-id=\"%d\",stopped-threads=\"all\"", k.stateThreadID(state))
+	return results
+}
+
+// returnValue renders values returned by a function. As a Go function
+// can return more than one value, multiple values are shown as a tuple,
+// e.g., (42, nil).
+func returnValue(values []api.Variable) string {
+	if len(values) == 1 {
+		return values[0].SinglelineString()
+	}
+	rendered := make([]string, len(values))
+	for i := range values {
+		rendered[i] = values[i].SinglelineString()
+	}
+	return "(" + strings.Join(rendered, ", ") + ")"
+}
+
+// stateLocation returns the location at which the target has stopped
+// according to the state, preferring the current thread's location.
+func stateLocation(state *api.DebuggerState) (api.Location, bool) {
+	if thread := state.CurrentThread; thread != nil {
+		return api.Location{PC: thread.PC, File: thread.File, Line: thread.Line, Function: thread.Function}, true
+	}
+	if g := state.SelectedGoroutine; g != nil {
+		return g.CurrentLoc, true
+	}
+	return api.Location{}, false
+}
+
+// stateThreadID returns the ID of the thread that stopped as the frontend
+// knows it: the goroutine's or, in threads model, the OS thread's.
+func (k *kabuta) stateThreadID(state *api.DebuggerState) int64 {
+	if k.threadModel == ThreadModelThreads {
+		if thread := state.CurrentThread; thread != nil {
+			return int64(thread.ID)
+		}
+		return 0
+	}
+	return stateGoroutineID(state)
+}
+
+// stateGoroutineID returns the ID of the goroutine that stopped.
+func stateGoroutineID(state *api.DebuggerState) int64 {
+	if g := state.SelectedGoroutine; g != nil {
+		return g.ID
+	}
+	if thread := state.CurrentThread; thread != nil {
+		return thread.GoroutineID
+	}
+	return 0
+}
+
+// miFrame formats the location as the contents of a GDB/MI frame tuple
+// of *stopped records (without the braces), e.g.:
+// addr="0x0000000000401000",func="main.main",file="main.go",fullname="/src/cli/main.go",line="12",arch="i386:x86-64",args=[]
+func miFrame(loc api.Location) string {
+	return miLocation(loc) + ",args=[]"
+}
+
+// functionName returns the name of the location's function,
+// or "??" if unknown, as in GDB.
+func functionName(loc api.Location) string {
+	if loc.Function == nil {
+		return "??"
+	}
+	return loc.Function.Name()
+}
+
+// miLocation formats the location as GDB/MI frame information, e.g.:
+// addr="0x0000000000401000",func="main.main",file="main.go",fullname="/src/cli/main.go",line="12",arch="i386:x86-64"
+func miLocation(loc api.Location) string {
+	return f("addr=\"0x%016x\",func=%s,file=%s,fullname=%s,line=\"%d\",arch=\"%s\"", loc.PC, miQuote(functionName(loc)), miQuote(filepath.Base(loc.File)), miQuote(loc.File), loc.Line, gdbArch())
+}
@@ -0,0 +1,116 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Display formats of varobjs and evaluated expressions.
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Variable-Objects.html
+const (
+	formatNatural         = "natural"
+	formatBinary          = "binary"
+	formatDecimal         = "decimal"
+	formatHexadecimal     = "hexadecimal"
+	formatOctal           = "octal"
+	formatZeroHexadecimal = "zero-hexadecimal"
+)
+
+// formatLetters maps the letters that GDB also accepts
+// for formats (as in -data-list-register-values) to the formats.
+var formatLetters = map[string]string{
+	"N": formatNatural,
+	"t": formatBinary,
+	"d": formatDecimal,
+	"x": formatHexadecimal,
+	"o": formatOctal,
+	"z": formatZeroHexadecimal,
+}
+
+// parseFormat parses the format given either by name or letter.
+func parseFormat(format string) (string, error) {
+	switch format {
+	case formatNatural, formatBinary, formatDecimal, formatHexadecimal, formatOctal, formatZeroHexadecimal:
+		return format, nil
+	}
+	if name, ok := formatLetters[format]; ok {
+		return name, nil
+	}
+	return "", NewError("Unknown display format: must be: \"natural\", \"binary\", \"decimal\", \"hexadecimal\", \"octal\" or \"zero-hexadecimal\"")
+}
+
+// integerBits returns the size in bits of the variable if it is
+// of an integer kind, 0 otherwise.
+func integerBits(v *api.Variable) int {
+	switch v.Kind {
+	case reflect.Int8, reflect.Uint8:
+		return 8
+	case reflect.Int16, reflect.Uint16:
+		return 16
+	case reflect.Int32, reflect.Uint32:
+		return 32
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return 64
+	}
+	return 0
+}
+
+// twosComplement returns the bits of the signed value as an unsigned
+// integer of the size (in bits; 0 meaning 64), as C reinterprets it.
+func twosComplement(n int64, bits int) uint64 {
+	u := uint64(n)
+	if bits > 0 && bits < 64 {
+		u &= 1<<uint(bits) - 1
+	}
+	return u
+}
+
+// formatInteger renders the value of the variable (or of the concrete
+// value of an interface) in the format. It returns false if the variable
+// is not an integer (or is unreadable), or if the format is natural,
+// in which case the value is to be rendered as usual. Like GDB, negative
+// values are shown in two's complement in all but decimal format.
+func formatInteger(v *api.Variable, format string) (string, bool) {
+	if v.Kind == reflect.Interface && !isNilVariable(v) {
+		v = pointee(v)
+	}
+	bits := integerBits(v)
+	if bits == 0 || format == formatNatural || format == "" || v.Unreadable != "" {
+		return "", false
+	}
+	var n uint64
+	if strings.HasPrefix(v.Value, "-") {
+		signed, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return "", false
+		}
+		if format == formatDecimal {
+			return v.Value, true
+		}
+		n = twosComplement(signed, bits)
+	} else {
+		var err error
+		n, err = strconv.ParseUint(v.Value, 10, 64)
+		if err != nil {
+			return "", false
+		}
+	}
+	switch format {
+	case formatBinary:
+		return strconv.FormatUint(n, 2), true
+	case formatDecimal:
+		return strconv.FormatUint(n, 10), true
+	case formatHexadecimal:
+		return "0x" + strconv.FormatUint(n, 16), true
+	case formatOctal:
+		if n == 0 {
+			return "0", true
+		}
+		return "0" + strconv.FormatUint(n, 8), true
+	case formatZeroHexadecimal:
+		return f("0x%0*x", bits/4, n), true
+	}
+	return "", false
+}
@@ -3,34 +3,58 @@ package kabuta
 import (
 	"flag"
 	"fmt"
-	//	"github.com/derekparker/delve/service/api"
-	"github.com/derekparker/delve/service/api"
-	"github.com/derekparker/delve/service/rpc2"
+	//	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"io/ioutil"
 	"net/rpc/jsonrpc"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
+// commandsWhileRunning lists methods processing commands that do not need
+// the target to be stopped, and thus may be invoked while it is running.
+var commandsWhileRunning = map[string]bool{
+	"ExecInterrupt":  true,
+	"Interrupt":      true,
+	"GdbExit":        true,
+	"GdbSet":         true,
+	"GdbShow":        true,
+	"GdbVersion":     true,
+	"InferiorTtySet": true,
+	"Set":            true,
+	"Source":         true,
+}
+
 // noopReturner returns empty result.
 func noopReturner() gdbMiResponse {
 	return gdbMiResponse{}
 }
 
-// gdbMiRecord represents GDB/MI2 output record,
+// gdbMiResponse represents GDB/MI2 output record,
 // either result (async is false: https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Result-Records.html#GDB_002fMI-Result-Records), or
 // async (async is true: https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Async-Records.html#GDB_002fMI-Async-Records)
 // The communicating to the frontend of stream (https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Stream-Records.html#GDB_002fMI-Stream-Records)
 // records is handled in sendConsoleStreamRecord and sendOutputStreamRecord.
-type gdbMiRecord struct {
+type gdbMiResponse struct {
 	// done, running, etc.
 	state   string
 	results string
+	// Already formatted stream records to be sent before the result record
+	// (e.g., the banner in response to -gdb-version).
+	streams string
 	// if not nil, state is assumed to be "error"
 	err   error
 	async bool
+	// Delve command (one of api.DebuggerCommand names, such as api.Continue)
+	// to be executed in the background once this response has been sent
+	// to the frontend. Only meaningful if state is "running".
+	dlvCommand string
 }
 
 // gdbCmd represents either GDB or MI command as described in
@@ -61,12 +85,9 @@ func (c gdbCmd) String() string {
 // process finds the appropriate method on gdbCmd to process
 // the command, or return dontKnowError if not found.
 func (c *gdbCmd) process() {
-	if c.args != nil && len(c.args) > 0 {
-		c.flagSet = flag.NewFlagSet(c.cmd, flag.ContinueOnError)
-		if err != nil {
-			c.frontendRequest.kabuta.log("Error parsing arguments %s for %s: %s", c.cmd, c.argsStr, err)
-		}
-	}
+	// Methods define the flags they expect and parse the arguments.
+	c.flagSet = flag.NewFlagSet(c.cmd, flag.ContinueOnError)
+	c.flagSet.SetOutput(ioutil.Discard)
 	c.frontendRequest.kabuta.log("Command: %s", *c)
 	var cmdSep string
 	if c.isMiCmd {
@@ -83,129 +104,532 @@ func (c *gdbCmd) process() {
 
 	method, mExist := reflect.TypeOf(c).MethodByName(methodName)
 	if !mExist {
-		msg := f("Unknown command %s: no method to process it \"%s\" found.", c, methodName)
-		c.respondError(msg)
+		c.respond(returnErrorf("Unknown command %s: no method to process it \"%s\" found.", c, methodName))
+		return
+	}
+	// While the target runs, Delve would not answer until it stops,
+	// and we cannot afford blocking here as the command to
+	// interrupt it would not be processed.
+	if c.frontendRequest.kabuta.isRunning() && !commandsWhileRunning[methodName] {
+		c.respond(returnErrorf("Cannot execute this command while the target is running.\nUse the \"interrupt\" command to stop the target\nand then try again."))
 		return
 	}
 
+	restoreSelection, resp := c.applyGlobalOptions()
+	if resp.err != nil {
+		c.respond(resp)
+		return
+	}
 	self := reflect.ValueOf(c)
 	args := []reflect.Value{self}
 	retval := method.Func.Call(args)
+	restoreSelection()
 	//	c.frontendRequest.kabuta.log("Calling method %s, got %v", method.Name, retval)
-	if len(retval) != 3 {
-		c.respondError("Method %s for command %s was expected to return 2 values, returned %v", methodName, c.cmd, retval)
+	if len(retval) != 1 {
+		c.respond(returnErrorf("Method %s for command %s was expected to return 1 value, returned %v", methodName, c.cmd, retval))
+		return
 	}
 
-	response := retval.Interface().(gdbMiResponse)
-	respond(response)
+	response := retval[0].Interface().(gdbMiResponse)
+	c.respond(response)
+	if response.err == nil && response.dlvCommand != "" {
+		go c.frontendRequest.kabuta.execute(response.dlvCommand)
+	}
 }
 
-// respond responds to the Frontend's request with an error message
-func (c *gdbCmd) respond(resp gdbMiRecord) {
+// globalOptionsRegexp matches the options that any MI command may
+// start with (see applyGlobalOptions()).
+var globalOptionsRegexp = regexp.MustCompile(`^(--(thread|frame|thread-group)\s+\S+\s*)+`)
+
+// applyGlobalOptions removes the --thread, --frame and --thread-group
+// options from the arguments of an MI command and selects the goroutine
+// and frame they name for the duration of the command, as GDB does.
+// The function returned restores the selection, unless the command
+// changed it (e.g., -thread-select). As selecting an OS thread that runs
+// no goroutine switches Delve to it (see threadGoroutineID()), Delve is
+// switched back too, unless the command resumed the target.
+// See https://sourceware.org/gdb/onlinedocs/gdb/Context-management.html
+func (c *gdbCmd) applyGlobalOptions() (func(), gdbMiResponse) {
+	noop := func() {}
+	prefix := globalOptionsRegexp.FindString(c.argsStr)
+	if !c.isMiCmd || prefix == "" {
+		return noop, noopReturner()
+	}
+	options := splitArgs(prefix)
+	c.argsStr = strings.TrimSpace(c.argsStr[len(prefix):])
+	c.args = splitArgs(c.argsStr)
+	k := c.frontendRequest.kabuta
+	goroutineID, threadID, frame := k.selectedGoroutineID, k.selectedThreadID, k.selectedFrame
+	dlvThreadID := k.dlvThreadID()
+	restoreDlvThread := func() {
+		if dlvThreadID != 0 && k.dlvThreadID() != dlvThreadID && !k.isRunning() {
+			err := k.switchThread(dlvThreadID)
+			if err != nil {
+				k.log("applyGlobalOptions(): %s", err)
+			}
+		}
+	}
+	for i := 0; i+1 < len(options); i += 2 {
+		n, err := strconv.Atoi(options[i+1])
+		switch options[i] {
+		case "--thread":
+			if err != nil || n <= 0 {
+				restoreDlvThread()
+				return noop, returnErrorf("Invalid thread id: %s", options[i+1])
+			}
+			if int64(n) == k.currentThreadID() {
+				continue
+			}
+			frame = 0
+			goroutineID = int64(n)
+			if k.threadModel == ThreadModelThreads {
+				threadID = int64(n)
+				goroutineID, err = k.threadGoroutineID(threadID)
+				if err != nil {
+					restoreDlvThread()
+					return noop, returnError(err)
+				}
+			}
+		case "--frame":
+			if err != nil || n < 0 {
+				restoreDlvThread()
+				return noop, returnErrorf("Invalid frame level: %s", options[i+1])
+			}
+			frame = n
+		}
+	}
+	prevGoroutineID, prevThreadID, prevFrame := k.selectedGoroutineID, k.selectedThreadID, k.selectedFrame
+	k.selectedGoroutineID, k.selectedThreadID, k.selectedFrame = goroutineID, threadID, frame
+	generation := k.selectionGeneration
+	return func() {
+		if k.selectionGeneration != generation {
+			return
+		}
+		k.selectedGoroutineID, k.selectedThreadID, k.selectedFrame = prevGoroutineID, prevThreadID, prevFrame
+		restoreDlvThread()
+	}, noopReturner()
+}
+
+// respond responds to the Frontend's request in the proper format
+// (including corresponding token and command execution info).
+func (c *gdbCmd) respond(resp gdbMiResponse) {
 	frontReq := c.frontendRequest
 	k := frontReq.kabuta
+	if frontReq.console {
+		c.respondToConsole(resp)
+		return
+	}
 	var response string
 	if resp.err == nil {
 		if resp.state == "" {
 			resp.state = "done"
 		}
-
+		if resp.streams != "" {
+			response = resp.streams + "\n"
+		}
+		results := resp.results
+		if results != "" {
+			results += ","
+		}
 		if c.isMiCmd {
-			if s1 != "" {
-				response = s1 + "\n"
-			}
-			if s2 != "" {
-				s2 += ","
-			}
-			response += f("%s^%s,%s%s\n", frontReq.token, resp.state, resp.s2, frontReq.gdbSummary())
+			response += f("%s^%s,%s%s\n", frontReq.token, resp.state, results, frontReq.gdbSummary())
 		} else {
-			// Temporary
-			response = f("%s^%s\n", resp.state, frontReq.token)
-			//		c.frontendRequest.kabuta.log("Unsure what to do with respond(%s,%s) in %s", s1, s2, c)
+			response += f("%s^%s\n", frontReq.token, resp.state)
+		}
+		if resp.state == "running" {
+			// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Async-Records.html
+			response += "*running,thread-id=\"all\"\n"
 		}
 	} else {
-		k.log("Error: %s", resp.err.String())
-		response = f("%s^error,msg=\"%s\"", resp.token, resp.err.String())
+		k.log("Error: %s", resp.err)
+		response = f("%s^error,msg=%s\n", frontReq.token, miQuote(resp.err.Error()))
 	}
 	k.writeToFrontend(response)
 }
 
+// respondToConsole sends what would have been the response to the console
+// stream instead, as the request did not come from the frontend.
+func (c *gdbCmd) respondToConsole(resp gdbMiResponse) {
+	k := c.frontendRequest.kabuta
+	if resp.err != nil {
+		k.log("Error: %s", resp.err)
+		k.sendConsoleStreamRecord("%s\n", resp.err)
+		return
+	}
+	if resp.streams != "" {
+		k.writeToFrontend(resp.streams + "\n")
+	}
+	if resp.results != "" {
+		k.sendConsoleStreamRecord("%s\n", resp.results)
+	}
+	if resp.state == "running" {
+		k.writeToFrontend("*running,thread-id=\"all\"\n")
+	}
+}
+
 // dontKnowError is used when we don't know how to handle a given command.
 // It is primarily intended as a placeholder during development.
 func (c *gdbCmd) dontKnowError() gdbMiResponse {
 	return returnErrorf("Don't know how to handle \"%s\"", c)
 }
 
+// reverseError is returned for the --reverse flag of exec commands,
+// as Delve can only execute backwards when replaying a recording.
+func reverseError() gdbMiResponse {
+	return returnErrorf("Target does not support this command.")
+}
+
+// hasOption returns true if the option (such as "--reverse") is
+// among the command's arguments.
+func (c *gdbCmd) hasOption(option string) bool {
+	for _, arg := range c.args {
+		if arg == option {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *gdbCmd) sendConsoleStreamRecord(s string, args ...interface{}) {
-	c.frontendRequest.kabuta.writeToFrontend("~" + f(s, args))
+	c.frontendRequest.kabuta.sendConsoleStreamRecord(s, args...)
 }
 
 func (c *gdbCmd) sendOutputStreamRecord(s string, args ...interface{}) {
-	c.frontendRequest.kabuta.writeToFrontend("@" + f(s, args))
+	c.frontendRequest.kabuta.writeToFrontend("@" + miQuote(f(s, args...)) + "\n")
 }
 
 // 9*stopped,time={wallclock="0.09920",user="0.03285",system="0.03430",start="1475679819.184591",end="1475679819.283786"},
 // reason="breakpoint-hit",commands="no",times="1",bkptno="1",thread-id="2"
 
-// respond responds to the Frontend's request in the proper format
-// (including corresponding token and command execution info).
+// BreakAfter is invoked in response to break-after GDB MI command.
+// The ignore count is implemented with Delve's hit condition, which
+// Delve checks against all the hits so far, so the hits to ignore
+// are counted from the current hit count.
+func (c *gdbCmd) BreakAfter() gdbMiResponse {
+	if len(c.args) != 2 {
+		return returnErrorf("Usage: -break-after NUMBER COUNT")
+	}
+	bp, resp := c.breakpointArg(c.args[0])
+	if bp == nil {
+		return resp
+	}
+	count, err := strconv.Atoi(c.args[1])
+	if err != nil || count < 0 {
+		return returnErrorf("Invalid ignore count: %s", c.args[1])
+	}
+	k := c.frontendRequest.kabuta
+	hitCount, err := k.dlvHitCount(bp)
+	if err != nil {
+		return returnError(err)
+	}
+	bp.ignoreCount = count
+	bp.ignoreBase = hitCount
+	bp.updateDlvBreakpoint()
+	err = k.amendBreakpoint(bp)
+	if err != nil {
+		return returnError(err)
+	}
+	return noopReturner()
+}
 
+// BreakCommands is invoked in response to break-commands GDB MI command.
+// The commands (MI or CLI) are run when the breakpoint stops the target
+// (see kabuta.runBreakpointCommands()); without commands, the breakpoint's
+// commands are cleared.
+func (c *gdbCmd) BreakCommands() gdbMiResponse {
+	if len(c.args) == 0 {
+		return returnErrorf("Usage: -break-commands NUMBER [COMMAND...]")
+	}
+	bp, resp := c.breakpointArg(c.args[0])
+	if bp == nil {
+		return resp
+	}
+	bp.commands = c.args[1:]
+	return noopReturner()
+}
+
+// BreakCondition is invoked in response to break-condition GDB MI command.
+// An empty condition makes the breakpoint unconditional.
+func (c *gdbCmd) BreakCondition() gdbMiResponse {
+	args := c.args
+	// Delve checks the condition when it is hit, so there is nothing to force.
+	if len(args) > 0 && args[0] == "--force" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return returnErrorf("Usage: -break-condition [--force] NUMBER [EXPR]")
+	}
+	bp, resp := c.breakpointArg(args[0])
+	if bp == nil {
+		return resp
+	}
+	bp.condition = strings.Join(args[1:], " ")
+	bp.updateDlvBreakpoint()
+	err := c.frontendRequest.kabuta.amendBreakpoint(bp)
+	if err != nil {
+		return returnError(err)
+	}
+	return noopReturner()
+}
+
+// BreakDelete is invoked in response to break-delete GDB MI command.
+// Without arguments, all breakpoints are deleted.
+func (c *gdbCmd) BreakDelete() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	bps, resp := c.breakpointsArgs()
+	if resp.err != nil {
+		return resp
+	}
+	for _, bp := range bps {
+		err := k.deleteBreakpoint(bp)
+		if err != nil {
+			return returnError(err)
+		}
+	}
+	return noopReturner()
+}
+
+// BreakDisable is invoked in response to break-disable GDB MI command.
+func (c *gdbCmd) BreakDisable() gdbMiResponse {
+	return c.enableBreakpoints(false)
+}
+
+// BreakEnable is invoked in response to break-enable GDB MI command.
+func (c *gdbCmd) BreakEnable() gdbMiResponse {
+	return c.enableBreakpoints(true)
+}
+
+// enableBreakpoints enables or disables breakpoints whose
+// numbers are the command's arguments (all, if none are given).
+func (c *gdbCmd) enableBreakpoints(enable bool) gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	bps, resp := c.breakpointsArgs()
+	if resp.err != nil {
+		return resp
+	}
+	for _, bp := range bps {
+		bp.dlvBreakpoint.Disabled = !enable
+		err := k.amendBreakpoint(bp)
+		if err != nil {
+			return returnError(err)
+		}
+	}
+	return noopReturner()
+}
+
+// breakpointsArgs returns the breakpoints whose numbers are given
+// by the command's arguments, or all breakpoints if there are no arguments.
+func (c *gdbCmd) breakpointsArgs() ([]*breakpoint, gdbMiResponse) {
+	if len(c.args) == 0 {
+		return c.frontendRequest.kabuta.sortedBreakpoints(), gdbMiResponse{}
+	}
+	var bps []*breakpoint
+	for _, arg := range c.args {
+		bp, resp := c.breakpointArg(arg)
+		if bp == nil {
+			return nil, resp
+		}
+		bps = append(bps, bp)
+	}
+	return bps, gdbMiResponse{}
+}
+
+// breakpointArg returns the breakpoint whose number is given by the argument.
+// If there is no such breakpoint, nil and the error response are returned.
+func (c *gdbCmd) breakpointArg(arg string) (*breakpoint, gdbMiResponse) {
+	bpNo, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, returnErrorf("Bad breakpoint argument: '%s'", arg)
+	}
+	bp := c.frontendRequest.kabuta.breakpoints[bpNo]
+	if bp == nil {
+		return nil, returnErrorf("No breakpoint number %d.", bpNo)
+	}
+	return bp, gdbMiResponse{}
+}
+
+// BreakInsert is invoked in response to break-insert GDB MI command.
 // See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Breakpoint-Commands.html
 func (c *gdbCmd) BreakInsert() gdbMiResponse {
 	//	      -break-insert [ -t ] [ -h ] [ -f ] [ -d ] [ -a ]
 	//         [ -c condition ] [ -i ignore-count ]
 	//         [ -p thread-id ] [ location ]
-	c.flagSet.Bool("t", false, "")
-	c.flagSet.Parse(c.args)
+	// Delve only has software breakpoints, so -h is accepted and ignored.
+	c.flagSet.Bool("h", false, "")
+	tracepoint := c.flagSet.Bool("a", false, "")
+	bp, _, resp := c.parseBreakpoint()
+	if bp == nil {
+		return resp
+	}
+	bp.dlvBreakpoint.Tracepoint = *tracepoint
+	return c.insertBreakpoint(bp)
+}
 
-	args := c.flagSet.Args()
-	if args == nil || len(args) == 0 {
-		return dontKnowError()
+// parseBreakpoint parses options and location common to break-insert and
+// dprintf-insert, returning the new breakpoint and the arguments that follow
+// the location. If the breakpoint cannot be created, nil and the error
+// response are returned.
+func (c *gdbCmd) parseBreakpoint() (*breakpoint, []string, gdbMiResponse) {
+	temporary := c.flagSet.Bool("t", false, "")
+	pending := c.flagSet.Bool("f", false, "")
+	disabled := c.flagSet.Bool("d", false, "")
+	condition := c.flagSet.String("c", "", "")
+	ignoreCount := c.flagSet.Int("i", 0, "")
+	threadID := c.flagSet.Int("p", 0, "")
+	// Explicit locations, see
+	// https://sourceware.org/gdb/onlinedocs/gdb/Explicit-Locations.html
+	source := c.flagSet.String("source", "", "")
+	function := c.flagSet.String("function", "", "")
+	label := c.flagSet.String("label", "", "")
+	line := c.flagSet.String("line", "", "")
+	flagArgs := c.args
+	var args []string
+	// A negative offset would be taken for a flag, so
+	// stop parsing flags at it.
+	for i, arg := range c.args {
+		if lineOffsetRegexp.MatchString(arg) && (i == 0 || !breakpointValueFlags[c.args[i-1]]) {
+			flagArgs = c.args[:i]
+			args = c.args[i:]
+			break
+		}
 	}
-	bp, err := newBreakpoint(args[0])
+	err := c.flagSet.Parse(flagArgs)
 	if err != nil {
-		return returnError(err)
+		return nil, nil, returnErrorf("Error parsing arguments %s: %s", c.argsStr, err)
 	}
-	// If Delve is already running, send the breakpoint there.
+	if args == nil {
+		args = c.flagSet.Args()
+	}
+
+	location := explicitLocation(*source, *function, *label, *line)
+	if location == "" && *label == "" && len(args) > 0 {
+		location = args[0]
+		args = args[1:]
+	}
+	k := c.frontendRequest.kabuta
+	var bp *breakpoint
+	switch {
+	case location != "":
+		bp, err = k.newBreakpoint(location, *pending || k.breakpointPending)
+	case *label != "":
+		bp, err = k.newLabelBreakpoint(*label, *pending || k.breakpointPending)
+	default:
+		return nil, nil, c.dontKnowError()
+	}
+	if err != nil {
+		return nil, nil, returnError(err)
+	}
+	bp.temporary = *temporary
+	bp.condition = *condition
+	bp.ignoreCount = *ignoreCount
+	bp.threadID = *threadID
+	bp.osThread = k.threadModel == ThreadModelThreads
+	bp.dlvBreakpoint.Disabled = *disabled
+	bp.updateDlvBreakpoint()
+	return bp, args, gdbMiResponse{}
+}
+
+// insertBreakpoint creates the breakpoint in Delve (if Delve is already
+// running, otherwise ExecRun will), and responds with its information.
+func (c *gdbCmd) insertBreakpoint(bp *breakpoint) gdbMiResponse {
 	k := c.frontendRequest.kabuta
-	// Some dummy address for now..
-	addr := "0x0000000100000f78"
-	funcName := "dummy"
-	fileName := bp.fileName
-	lineNo := bp.lineNo
 	if k.dlvRpcClient != nil {
-		bpIn := rpc2.CreateBreakpointIn{Breakpoint: *bp.dlvBreakpoint}
-		bpOut := &rpc2.CreateBreakpointOut{}
-		err = k.dlvRpcClient.Call("RPCServer.CreateBreakpoint", bpIn, bpOut)
+		err := k.createDlvBreakpoint(bp)
 		if err != nil {
-			return returnErrorf("Error setting breakpoint %s: %s", bp, err)
+			return returnError(err)
 		}
-		bp.dlvBreakpoint = &bpOut.Breakpoint
-		addr = f("%x", bp.dlvBreakpoint.Addr)
-		funcName = bp.dlvBreakpoint.FunctionName
-		fileName = bp.dlvBreakpoint.File
-		lineNo = bp.dlvBreakpoint.Line
-		k.log("Set breakpoint %v", bp.dlvBreakpoint)
 	}
-	c.frontendRequest.kabuta.breakpoints = append(c.frontendRequest.kabuta.breakpoints, bp)
-	bpNo := len(c.frontendRequest.kabuta.breakpoints) + 1
-	resp := fmt.Sprintf("bkpt={number=\"%d\",type=\"breakpoint\",disp=\"keep\",enabled=\"y\",", bpNo)
-	resp += f("addr=\"%s\"", addr)
-	resp += f("func=\"%s\",file=\"%s\",line=\"%d\",", funcName, fileName, lineNo)
-	resp += f("shlib=\"%s\",times=\"0\"}", c.frontendRequest.kabuta.debugBinaryPath)
-	return "", resp, nil
+	k.addBreakpoint(bp)
+	return gdbMiResponse{results: k.miBreakpoint(bp)}
+}
+
+// lineOffsetRegexp matches +OFFSET and -OFFSET linespecs.
+var lineOffsetRegexp = regexp.MustCompile(`^[+-][0-9]+$`)
+
+// breakpointValueFlags are options of break-insert and dprintf-insert
+// that take a value.
+var breakpointValueFlags = map[string]bool{
+	"-c":         true,
+	"-i":         true,
+	"-p":         true,
+	"--source":   true,
+	"--function": true,
+	"--label":    true,
+	"--line":     true,
+}
+
+// explicitLocation converts the explicit location (given by --source,
+// --function, --label and --line options) to the linespec. A label without
+// a function has no linespec equivalent, in which case "" is returned.
+func explicitLocation(source, function, label, line string) string {
+	switch {
+	case source != "" && line != "":
+		return source + ":" + line
+	case source != "" && function != "":
+		return source + ":" + function
+	case function != "" && label != "":
+		return function + ":" + label
+	case function != "":
+		return function
+	case line != "":
+		return line
+	}
+	return ""
 }
 
+// BreakList is invoked in response to break-list GDB MI command.
+func (c *gdbCmd) BreakList() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	err := k.refreshBreakpoints()
+	if err != nil {
+		return returnError(err)
+	}
+	bps := k.sortedBreakpoints()
+	body := make([]string, len(bps))
+	for i, bp := range bps {
+		body[i] = k.miBreakpoint(bp)
+	}
+	table := f("BreakpointTable={nr_rows=\"%d\",nr_cols=\"6\",%s,body=[%s]}", len(bps), miBreakpointTableHeader, strings.Join(body, ","))
+	return gdbMiResponse{results: table}
+}
+
+// Continue is the CLI version of ExecContinue.
+func (c *gdbCmd) Continue() gdbMiResponse {
+	return c.ExecContinue()
+}
+
+// DataEvaluateExpression is invoked in response to data-evaluate-expression
+// GDB MI command. The expression is evaluated by Delve in the selected frame
+// and, if it is an integer, rendered in the format given by -f.
 func (c *gdbCmd) DataEvaluateExpression() gdbMiResponse {
-	dontKnowHowReturner := func() gdbMiResponse {
-		return "", "", NewError("Don't know how to evaluate %s", c.argsStr)
+	args := c.args
+	format := formatNatural
+	if len(args) > 1 && args[0] == "-f" {
+		var err error
+		format, err = parseFormat(args[1])
+		if err != nil {
+			return returnError(err)
+		}
+		args = args[2:]
 	}
-	switch c.argsStr {
-	case "\"sizeof (void*)\"":
-		return "", "value=\"8\"", nil
-	default:
-		return dontKnowHowReturner()
+	if len(args) == 0 {
+		return returnErrorf("-data-evaluate-expression: Usage: -data-evaluate-expression [-f FORMAT] EXPRESSION")
+	}
+	expr := strings.Join(args, " ")
+	// CDT asks for this to figure out the address size,
+	// which is not a Go expression.
+	if expr == "sizeof (void*)" {
+		return gdbMiResponse{results: "value=\"8\""}
 	}
+	k := c.frontendRequest.kabuta
+	v, err := k.eval(expr, k.evalScope())
+	if err != nil {
+		return returnError(err)
+	}
+	if s, ok := formatInteger(v, format); ok {
+		return gdbMiResponse{results: f("value=%s", miQuote(s))}
+	}
+	return gdbMiResponse{results: f("value=%s", miQuote(v.SinglelineString()))}
 }
 
 // EnvironmentCd reacts to environment-cd command - it sets
@@ -225,14 +649,14 @@ func (c *gdbCmd) EnvironmentCd() gdbMiResponse {
 	gopath += cwd
 	err := os.Setenv("GOPATH", cwd)
 	if err != nil {
-		return "", "", NewError("Error setting GOPATH to \"%s\"", cwd)
+		return returnErrorf("Error setting GOPATH to \"%s\"", cwd)
 	}
 	// TODO document what's going on here.
 	grep := fmt.Sprintf("grep -r '^package main$'  %s | grep -v vendor", cwd)
 	grepCmd := exec.Command("/bin/bash", "-c", grep)
 	out, err := grepCmd.CombinedOutput()
 	if err != nil {
-		return "", "", NewError("Error running %s: %s", grep, err)
+		return returnErrorf("Error running %s: %s", grep, err)
 	}
 	outLines := strings.Split(string(out), "\n")
 	for _, line := range outLines {
@@ -253,8 +677,68 @@ func (c *gdbCmd) EnvironmentCd() gdbMiResponse {
 	return noopReturner()
 }
 
+// ExecContinue is invoked in response to exec-continue GDB MI command
+// (and the continue CLI command). It responds with ^running right away,
+// and the continuing itself happens in the background (see kabuta.execute()),
+// which reports the *stopped record once the target stops again.
+func (c *gdbCmd) ExecContinue() gdbMiResponse {
+	return c.execResponse(api.Continue)
+}
+
+// ExecFinish is invoked in response to exec-finish GDB MI command.
+// It steps out of the current function using Delve's stepOut command;
+// the *stopped record then has the function-finished reason and
+// the returned values.
+func (c *gdbCmd) ExecFinish() gdbMiResponse {
+	if c.hasOption("--reverse") {
+		return reverseError()
+	}
+	return c.execResponse(api.StepOut)
+}
+
+// ExecInterrupt is invoked in response to exec-interrupt GDB MI command.
+// It asks Delve to halt the target, which makes the pending command
+// (see kabuta.execute()) return and report the *stopped record
+// with signal-received reason.
+func (c *gdbCmd) ExecInterrupt() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	if k.dlvRpcClient == nil {
+		return returnErrorf("The program is not being run.")
+	}
+	if !k.interrupt() {
+		k.log("ExecInterrupt(): Target is not running, nothing to do")
+		return noopReturner()
+	}
+	// Delve answers halt only once the target has stopped, so
+	// do not wait for it here.
+	go func() {
+		haltOut := rpc2.CommandOut{}
+		err := k.dlvRpcClient.Call("RPCServer.Command", api.DebuggerCommand{Name: api.Halt}, &haltOut)
+		if err != nil {
+			k.log("ExecInterrupt(): Error halting: %s", err)
+		}
+	}()
+	return noopReturner()
+}
+
+// ExecNext is invoked in response to exec-next GDB MI command.
+func (c *gdbCmd) ExecNext() gdbMiResponse {
+	if c.hasOption("--reverse") {
+		return reverseError()
+	}
+	return c.execResponse(api.Next)
+}
+
+// ExecNextInstruction is invoked in response to exec-next-instruction GDB MI command.
+func (c *gdbCmd) ExecNextInstruction() gdbMiResponse {
+	if c.hasOption("--reverse") {
+		return reverseError()
+	}
+	return c.execResponse(api.NextInstruction)
+}
+
 // ExecRun is invoked in response to exec-run GDB MI command.
-// It launches Delve as described in https://github.com/derekparker/delve/tree/master/Documentation/api.
+// It launches Delve as described in https://github.com/go-delve/delve/tree/master/Documentation/api.
 // In particular:
 //   1. Binary specified by EnvKabutaDlvPath is run
 //   2. It is run directory as determined by FileExecAndSymbols
@@ -268,13 +752,13 @@ func (c *gdbCmd) ExecRun() gdbMiResponse {
 	dlv := k.dlvPath
 	dlvAddr := f("127.0.0.1:%d", k.dlvPort)
 	listenArg := f("--listen=%s", dlvAddr)
-	// See https://github.com/derekparker/delve/tree/master/Documentation/api
+	// See https://github.com/go-delve/delve/tree/master/Documentation/api
 	k.dlvCmd = exec.Command(dlv, "debug", "--headless", "--log", "--api-version=2", listenArg, "--", k.debugBinaryArgs)
 	k.dlvCmd.Dir = k.debugBinaryPackageDir
 	k.dlvStdout, err = k.dlvCmd.StdoutPipe()
 	cmdLine := strings.Join(k.dlvCmd.Args, " ")
 	if err != nil {
-		return returnErrorf("Error running %s in %s: %", cmdLine, k.debugBinaryPackageDir, err)
+		return returnErrorf("Error running %s in %s: %s", cmdLine, k.debugBinaryPackageDir, err)
 	}
 	k.dlvStderr, err = k.dlvCmd.StderrPipe()
 	if err != nil {
@@ -284,7 +768,7 @@ func (c *gdbCmd) ExecRun() gdbMiResponse {
 	err = k.dlvCmd.Start()
 	k.log("ExecRun(): After Start()")
 	if err != nil {
-		return "", "", NewError("Error running %s in %s: %s", cmdLine, k.debugBinaryPackageDir, err)
+		return returnErrorf("Error running %s in %s: %s", cmdLine, k.debugBinaryPackageDir, err)
 	}
 	k.log("ExecRun(): dlv pid: %d", k.dlvCmd.Process.Pid)
 	go k.dlvReadLoop(true)
@@ -303,21 +787,53 @@ func (c *gdbCmd) ExecRun() gdbMiResponse {
 	}
 	k.dlvRpcClient, err = jsonrpc.Dial("tcp", dlvAddr)
 	if err != nil {
-		return "", "", NewError("Error connecting to %s: %s", dlvAddr, err)
+		return returnErrorf("Error connecting to %s: %s", dlvAddr, err)
 	}
 	// Set breakpoints that have been requested so far.
-	for _, bp := range k.breakpoints {
-		bpIn := rpc2.CreateBreakpointIn{Breakpoint: *bp.dlvBreakpoint}
-		bpOut := &rpc2.CreateBreakpointOut{}
-		err = k.dlvRpcClient.Call("RPCServer.CreateBreakpoint", bpIn, bpOut)
+	for _, bp := range k.sortedBreakpoints() {
+		err = k.createDlvBreakpoint(bp)
 		if err != nil {
-			return returnErrorf("Error setting breakpoint %s: %s", bp, err)
+			return returnError(err)
 		}
-		bp.dlvBreakpoint = &bpOut.Breakpoint
-		k.log("Set breakpoint %v", bp.dlvBreakpoint)
 	}
 
-	return 9 ^ running()
+	// Delve starts the target halted, so let it go.
+	return c.execResponse(api.Continue)
+}
+
+// ExecStep is invoked in response to exec-step GDB MI command.
+func (c *gdbCmd) ExecStep() gdbMiResponse {
+	if c.hasOption("--reverse") {
+		return reverseError()
+	}
+	return c.execResponse(api.Step)
+}
+
+// ExecStepInstruction is invoked in response to exec-step-instruction GDB MI command.
+func (c *gdbCmd) ExecStepInstruction() gdbMiResponse {
+	if c.hasOption("--reverse") {
+		return reverseError()
+	}
+	return c.execResponse(api.StepInstruction)
+}
+
+// execResponse checks that the target can be resumed and, if so, returns a
+// "running" response that will cause dlvCommand to be executed by Delve
+// after the response is sent.
+func (c *gdbCmd) execResponse(dlvCommand string) gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	if k.dlvRpcClient == nil {
+		return returnErrorf("The program is not being run.")
+	}
+	// Steps apply to the goroutine Delve has selected.
+	err := k.switchGoroutine()
+	if err != nil {
+		return returnError(err)
+	}
+	if !k.startExecution() {
+		return returnErrorf("Cannot execute this command while the selected thread is running.")
+	}
+	return gdbMiResponse{state: "running", dlvCommand: dlvCommand}
 }
 
 // FileExecAndSymbols is invoked in response to file-exec-and-symbols GDB MI command.
@@ -329,13 +845,19 @@ func (c *gdbCmd) FileExecAndSymbols() gdbMiResponse {
 	k := c.frontendRequest.kabuta
 	k.debugBinaryPath = c.args[0]
 	k.debugBinaryPackageDir = k.debugBinaryToPackageDir[filepath.Base(c.args[0])]
+	k.debugBinaryPackageDirs = nil
 	if k.debugBinaryPackageDir == "" {
-		return "", "", NewError("Cannot determine package directory for %s", c.args[0])
+		return returnErrorf("Cannot determine package directory for %s", c.args[0])
 	}
 	k.log("FileExecAndSymbols(): Package directory: %s", k.debugBinaryPackageDir)
 	return noopReturner()
 }
 
+// Finish is the CLI version of ExecFinish.
+func (c *gdbCmd) Finish() gdbMiResponse {
+	return c.ExecFinish()
+}
+
 // GdbExit exits the process.
 func (c *gdbCmd) GdbExit() gdbMiResponse {
 	c.frontendRequest.kabuta.log("Exit command received. The Moor has done his duty, the Moor can go.")
@@ -343,12 +865,17 @@ func (c *gdbCmd) GdbExit() gdbMiResponse {
 	return noopReturner()
 }
 
+// GdbSet handles set commands: GDB settings, as well as
+// assignments to variables of the target (set var x = 1).
 func (c *gdbCmd) GdbSet() gdbMiResponse {
 	k := c.frontendRequest.kabuta
+	if len(c.args) == 0 {
+		return returnErrorf("Argument required (expression to compute).")
+	}
 	varName := c.args[0]
 	dontKnowHowReturner := func() gdbMiResponse {
 
-		return "", "", NewError("Don't know how to set %s", c.argsStr)
+		return returnErrorf("Don't know how to set %s", c.argsStr)
 	}
 	var varName2 string
 	switch varName {
@@ -360,10 +887,10 @@ func (c *gdbCmd) GdbSet() gdbMiResponse {
 				k.breakpointPending = true
 				return noopReturner()
 			} else if c.args[2] == "off" {
-				k.breakpointPending = true
+				k.breakpointPending = false
 				return noopReturner()
 			} else {
-				return "", "", NewError("Unknown value for breakpoint pending: %s", c.args[2])
+				return returnErrorf("Unknown value for breakpoint pending: %s", c.args[2])
 			}
 
 		default:
@@ -388,7 +915,7 @@ func (c *gdbCmd) GdbSet() gdbMiResponse {
 		argsStr := strings.Join(c.args[1:], " ")
 		kv := strings.Split(argsStr, " = ")
 		if len(kv) != 2 {
-			return "", "", NewError("Bad value for env %s", argsStr)
+			return returnErrorf("Bad value for env %s", argsStr)
 		}
 		os.Setenv(kv[0], kv[1])
 		return noopReturner()
@@ -396,29 +923,140 @@ func (c *gdbCmd) GdbSet() gdbMiResponse {
 		fallthrough
 	case "auto-solib-add":
 		return noopReturner()
+	case "kabuta":
+		return c.setKabuta()
+	case "var", "variable":
+		return c.assign(strings.TrimSpace(strings.TrimPrefix(c.argsStr, varName)))
 	default:
-		return "", "", NewError("Unknown variable: %s", varName)
+		// As in GDB, what is not a setting is an assignment.
+		if _, _, ok := splitAssignment(c.argsStr); ok {
+			return c.assign(c.argsStr)
+		}
+		return returnErrorf("Unknown variable: %s", varName)
 	}
-	return "", "", nil
+}
+
+// setKabuta handles settings specific to kabuta:
+// set kabuta thread-model goroutines|threads
+// set kabuta group-goroutines on|off
+// set kabuta show-system-goroutines on|off
+// The settings are read by execute() while the target runs, so
+// they can only be changed while it is stopped.
+func (c *gdbCmd) setKabuta() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	if len(c.args) != 3 {
+		return returnErrorf("Usage: set kabuta SETTING VALUE")
+	}
+	if k.isRunning() {
+		return returnErrorf("Cannot change kabuta settings while the target is running.")
+	}
+	switch c.args[1] {
+	case "group-goroutines":
+		group, err := parseOnOff(c.args[2])
+		if err != nil {
+			return returnError(err)
+		}
+		k.groupGoroutines = group
+		return noopReturner()
+	case "show-system-goroutines":
+		show, err := parseOnOff(c.args[2])
+		if err != nil {
+			return returnError(err)
+		}
+		k.showSystemGoroutines = show
+		// The frontend learns which goroutines are now listed.
+		k.notifyThreadChanges(false)
+		return noopReturner()
+	case "thread-model":
+		model := c.args[2]
+		if model != ThreadModelGoroutines && model != ThreadModelThreads {
+			return returnErrorf("Unknown thread model: %s, expected %s or %s", model, ThreadModelGoroutines, ThreadModelThreads)
+		}
+		if model != k.threadModel {
+			k.threadModel = model
+			// Thread IDs change meaning, so the selection is back
+			// to where the target stopped.
+			k.resetSelection()
+			k.notifyThreadChanges(false)
+		}
+		return noopReturner()
+	default:
+		return returnErrorf("Unknown kabuta setting: %s", c.args[1])
+	}
+}
+
+// splitAssignment splits the assignment, such as x = 1, into the variable
+// and the value. It returns false if there is no assignment: comparisons,
+// such as x == 1 or x >= 1, are not, and = within brackets or literals
+// (as in m["a=b"] = 1) does not count.
+func splitAssignment(s string) (string, string, bool) {
+	pos := -1
+	scanTopLevel(s, func(i int, r rune) bool {
+		if r != '=' || (i > 0 && strings.IndexByte("=!<>:", s[i-1]) >= 0) || (i+1 < len(s) && s[i+1] == '=') {
+			return true
+		}
+		pos = i
+		return false
+	})
+	if pos < 0 {
+		return "", "", false
+	}
+	variable, value := strings.TrimSpace(s[:pos]), strings.TrimSpace(s[pos+1:])
+	if variable == "" || value == "" {
+		return "", "", false
+	}
+	return variable, value, true
+}
+
+// assign assigns the value to the variable in the selected frame
+// given the assignment, such as x = 1.
+func (c *gdbCmd) assign(assignment string) gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	if k.isRunning() {
+		return returnErrorf("Cannot execute this command while the target is running.")
+	}
+	variable, value, ok := splitAssignment(assignment)
+	if !ok {
+		return returnErrorf("Expected an assignment, got: %s", assignment)
+	}
+	err := k.setVariable(variable, value, k.evalScope())
+	if err != nil {
+		return returnError(err)
+	}
+	return noopReturner()
 }
 
 // GdbShow handles show commands.
 func (c *gdbCmd) GdbShow() gdbMiResponse {
 	dontKnowHowReturner := func() gdbMiResponse {
-		return "", "", NewError("Don't know how to show %s", strings.Join(c.args, " "))
+		return returnErrorf("Don't know how to show %s", strings.Join(c.args, " "))
 	}
 	switch c.args[0] {
 	case "language":
-		return "", "value=\"auto; currently c\"", nil
+		return gdbMiResponse{results: "value=\"auto; currently c\""}
 	case "endian":
 		return noopReturner()
+	case "kabuta":
+		k := c.frontendRequest.kabuta
+		if len(c.args) != 2 {
+			return dontKnowHowReturner()
+		}
+		switch c.args[1] {
+		case "thread-model":
+			return gdbMiResponse{results: "value=" + miQuote(k.threadModel)}
+		case "group-goroutines":
+			return gdbMiResponse{results: "value=" + miQuote(onOff(k.groupGoroutines))}
+		case "show-system-goroutines":
+			return gdbMiResponse{results: "value=" + miQuote(onOff(k.showSystemGoroutines))}
+		}
+		return dontKnowHowReturner()
 	default:
 		return dontKnowHowReturner()
 	}
 }
 
 func (c *gdbCmd) GdbVersion() gdbMiResponse {
-	return strings.Join(miGdbVersion, "\n"), GdbVersionSummary, nil
+	return gdbMiResponse{streams: strings.Join(miGdbVersion, "\n"), results: GdbVersionSummary}
 }
 
 // Noop
@@ -426,166 +1064,53 @@ func (c *gdbCmd) InferiorTtySet() gdbMiResponse {
 	return noopReturner()
 }
 
-//11^done,threadno="3",frame={func="threadFunc",optimized="0",args=[{name="id",value="4006"}],file="main.c",fullname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/main.c",line="6",dir="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject",shlibname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/a.out"},threadno="2",frame={func="threadFunc",optimized="0",args=[{name="id",value="4006"}],file="main.c",fullname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/main.c",line="6",dir="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject",shlibname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/a.out"},threadno="1",frame={addr="0x00007fff908a7206",fp="0x00007fff5fbffa00",func="__semwait_signal",optimized="0",args=[],shlibname="/usr/lib/system/libsystem_kernel.dylib"}
-func (c *gdbCmd) InfoThreads() gdbMiResponse {
-	//	XXX
-	// 13^done,threadno="3",
-	//frame={func="threadFunc",optimized="0",
-	//args=[{name="id",value="4006"}],file="main.c",
-	//fullname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/main.c",
-	//line="6",dir="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject",
-	//shlibname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/a.out"},
-	//threadno="2",frame={func="threadFunc",optimized="0",args=[{name="id",value="4006"}],
-	//file="main.c",
-	//fullname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/main.c",
-	//line="6",
-	//dir="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject",
-	//shlibname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/a.out"},
-	//threadno="1",frame={addr="0x00007fff908a146a",fp="0x00007fff5fbff9b0",
-	//func="swtch_pri",optimized="0",args=[],shlibname="/usr/lib/system/libsystem_kernel.dylib"}
-	var goroutines []*api.Goroutine
-	k := c.frontendRequest.kabuta
-	gro := rpc2.ListGoroutinesOut{Goroutines: goroutines}
-	err := k.dlvRpcClient.Call("RPCServer.Goroutines", rpc2.ListGoroutinesIn{}, &gro)
-	if err != nil {
-		return returnErrorf("Error listing threads: %s", err)
+// Info is invoked in response to the info CLI command. Supported is:
+//   - info threads: list the threads (see InfoThreads())
+func (c *gdbCmd) Info() gdbMiResponse {
+	if len(c.args) == 0 {
+		return returnErrorf("\"info\" must be followed by the name of an info command.")
 	}
-
-	threadIds := ""
-	threadDetails := ""
-	result := ""
-	for i, g := range gro.Goroutines {
-		if len(result) > 0 {
-			result += ","
-		}
-		result += f("threadno=\"%d\"", i+1)
-
-		err := k.dlvRpcClient.Call("RPCServer.Goroutines", rpc2.ListGoroutinesIn{}, &gro)
-		if err != nil {
-			return returnErrorf("Error listing threads: %s", err)
-		}
+	subcommand := c.args[0]
+	c.args = c.args[1:]
+	switch subcommand {
+	case "threads":
+		return c.InfoThreads()
+	default:
+		return returnErrorf("Undefined info command: \"%s\".  Try \"help info\".", c.argsStr)
 	}
 }
 
-// Source is a no-op (should it not be?)
-func (c *gdbCmd) Source() gdbMiResponse {
-	return noopReturner()
+// Interrupt is the CLI version of ExecInterrupt.
+func (c *gdbCmd) Interrupt() gdbMiResponse {
+	return c.ExecInterrupt()
 }
 
-// StackInfoDepth does nothing at the moment, but returns the depth as specified
-// in the command. In other words, if called with
-// 11-stack-info-depth 4
-// will return
-// 11^done,depth="4"
-func (c *gdbCmd) StackInfoDepth() gdbMiResponse {
-	return gdbMiResponse{s2: f("depth=\"%s\"", c.args[0])}, nil
-}
-
-// 12^done,stack=[frame={level="0",addr="0x0000000100000df0",fp="0x0000700000080f00",
-// func="threadFunc",optimized="0",file="main.c",
-// fullname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/main.c",
-// line="6",dir="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject",
-// shlibname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/a.out"},
-// frame={level="1",addr="0x00007fff93670c13",fp="0x0000700000080f20",func="_pthread_body",optimized="0",
-// shlibname="/usr/lib/system/libsystem_pthread.dylib"},frame={level="2",addr="0x00007fff93670b90",
-// fp="0x0000700000080f60",func="_pthread_start",optimized="0",
-// shlibname="/usr/lib/system/libsystem_pthread.dylib"},
-// frame={level="3",addr="0x00007fff9366e375",fp="0x0000000000000000",
-// func="thread_start",optimized="0",
-// shlibname="/usr/lib/system/libsystem_pthread.dylib"}],
-// time={wallclock="0.00411",user="0.00095",system="0.00129",
-// start="1475680391.687718",end="1475680391.691833"}
-func (c *gdbCmd) StackListFrames() gdbMiResponse {
-	low := 0
-	high := 100
-	if len(c.args) > 0 {
-		low = c.args[0]
-		if len(c.args) > 1 {
-			high = c.args[1]
-		}
-	}
-	k := c.frontendRequest.kabuta
-	err := k.dlvRpcClient.Call("RPCServer.Stacktrace", rpc2.StacktraceIn{Cfg: loadConfig}, &gro)
-	if err != nil {
-		return "", "", NewError("Error listing threads: %s", err)
-	}
-
-	return "", f("depth=\"%s\"", c.args[0]), nil
+// Next is the CLI version of ExecNext.
+func (c *gdbCmd) Next() gdbMiResponse {
+	return c.ExecNext()
 }
 
-func (c *gdbCmd) StackSelectFrame() gdbMiResponse {
+// Nexti is the CLI version of ExecNextInstruction.
+func (c *gdbCmd) Nexti() gdbMiResponse {
+	return c.ExecNextInstruction()
+}
 
-	return dontKnowError()
+// Set is the CLI version of GdbSet.
+func (c *gdbCmd) Set() gdbMiResponse {
+	return c.GdbSet()
 }
 
-// ThreadListIds actually lists Goroutine IDs, because that is of
-// interest to the user.
-func (c *gdbCmd) ThreadListIds() gdbMiResponse {
-	var goroutines []*api.Goroutine
-	k := c.frontendRequest.kabuta
-	gro := rpc2.ListGoroutinesOut{Goroutines: goroutines}
-	err := k.dlvRpcClient.Call("RPCServer.Goroutines", rpc2.ListGoroutinesIn{}, &gro)
-	if err != nil {
-		return returnErrorf("Error listing threads: %s", err)
-	}
-
-	threadIds := ""
-	threadDetails := ""
-	for i, g := range gro.Goroutines {
-		if i > 0 {
-			threadIds += ","
-			threadDetails += ","
-		}
-		k.log("Thread information: %s", String(g))
-		tid := f("thread-id=\"%d\"", g.ID)
-		threadIds += tid
-		// State?
-		threadInfo := rpc2.GetThreadOut{}
-		err := k.dlvRpcClient.Call("RPCServer.GetThread", rpc2.GetThreadIn{Id: g.ThreadID}, &threadInfo)
-		if err != nil {
-			return "", "", NewError("Error listing threads: %s", err)
-		}
+// Source is a no-op (should it not be?)
+func (c *gdbCmd) Source() gdbMiResponse {
+	return noopReturner()
+}
 
-		if threadInfo.Thread.Breakpoint == nil {
-			state = "RUNNING"
-		} else {
-			state = "WAITING"
-		}
-		threadDetails += f("thread={%s,state=\"%s\",mach-port-number=\"0xffff\",pthread-id=\"%d\",unique-id=\"%d\"}", tid, state, t.GoroutineID, t.GoroutineID)
-	}
-	result := f("thread-ids={%s},number-of-threads=\"%d\",threads=[%s]", threadIds, len(gro.Goroutines), threadDetails)
-	return "", result, nil
+// Step is the CLI version of ExecStep.
+func (c *gdbCmd) Step() gdbMiResponse {
+	return c.ExecStep()
 }
 
-// Lists threads
-func (c *gdbCmd) ThreadListIdsXXX() gdbMiResponse {
-	var threads []*api.Thread
-	k := c.frontendRequest.kabuta
-	lto := rpc2.ListThreadsOut{Threads: threads}
-	err := k.dlvRpcClient.Call("RPCServer.ListThreads", rpc2.ListThreadsIn{}, &lto)
-	if err != nil {
-		return returnErrorf("Error listing threads: %s", err)
-	}
-
-	threadIds := ""
-	threadDetails := ""
-	for i, t := range lto.Threads {
-		if i > 0 {
-			threadIds += ","
-			threadDetails += ","
-		}
-		k.log("Thread information: %s", String(t))
-		tid := f("thread-id=\"%d\"", t.GoroutineID)
-		threadIds += tid
-		// State?
-		var state string
-		if t.Breakpoint == nil {
-			state = "RUNNING"
-		} else {
-			state = "WAITING"
-		}
-		threadDetails += f("thread={%s,state=\"%s\",mach-port-number=\"0xffff\",pthread-id=\"%d\",unique-id=\"%d\"}", tid, state, t.GoroutineID, t.GoroutineID)
-	}
-	result := f("thread-ids={%s},number-of-threads=\"%d\",threads=[%s]", threadIds, len(lto.Threads), threadDetails)
-	return "", result, nil
+// Stepi is the CLI version of ExecStepInstruction.
+func (c *gdbCmd) Stepi() gdbMiResponse {
+	return c.ExecStepInstruction()
 }
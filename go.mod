@@ -0,0 +1,16 @@
+module github.com/debedb/kabuta
+
+go 1.22.0
+
+require github.com/go-delve/delve v1.25.2
+
+require (
+	github.com/cilium/ebpf v0.11.0 // indirect
+	github.com/hashicorp/golang-lru v1.0.2 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	golang.org/x/arch v0.11.0 // indirect
+	golang.org/x/exp v0.0.0-20230224173230-c95f2b4c22f2 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/telemetry v0.0.0-20241106142447-58a1122356f5 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -1,7 +1,8 @@
 package kabuta
 
 import (
-	"github.com/derekparker/delve/service/api"
+	"bufio"
+	"github.com/go-delve/delve/service/api"
 	"io"
 	"net/rpc"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,6 +22,9 @@ type kabuta struct {
 	logFile         *os.File
 	frontendChannel chan string
 	dlvChannel      chan string
+	// Requests not coming from the frontend, such as breakpoint
+	// commands, to be processed by frontendWriteLoop.
+	internalChannel chan *frontendRequest
 	// Regexp for MI commands
 	miCmdRegexp *regexp.Regexp
 	// Regexp for CLI commands
@@ -39,33 +44,80 @@ type kabuta struct {
 	// Arguments to the binary
 	debugBinaryArgs         string
 	debugBinaryToPackageDir map[string]string
-	breakpoints             []*breakpoint
+	// Directories of all packages the binary is built from (see packageDirs())
+	debugBinaryPackageDirs []string
+	// Breakpoints by the number the frontend knows them by.
+	breakpoints map[int]*breakpoint
+	// Number of the last breakpoint created; numbers are never reused.
+	lastBreakpointNo int
+	// Serializes writes to the frontend, as async records are
+	// sent from goroutines other than frontendWriteLoop.
+	frontendLock sync.Mutex
+	// Guards running and interrupted.
+	execLock sync.Mutex
+	// Whether a Delve command that resumes the target is in progress.
+	running bool
+	// Whether the running target is being halted by exec-interrupt.
+	interrupted bool
+	// State of Delve as of the last time the target stopped.
+	dlvState *api.DebuggerState
+	// Goroutine and frame (0 being the innermost one) selected by
+	// the frontend, in which expressions are evaluated (see evalScope()).
+	selectedGoroutineID int64
+	selectedFrame       int
+	// OS thread selected by the frontend in threads model
+	// (see threadModel), whose goroutine is selectedGoroutineID.
+	selectedThreadID int64
+	// Incremented whenever the selection changes (see selectGoroutine(),
+	// selectFrame() and resetSelection()), so that applyGlobalOptions()
+	// can tell whether a command changed it.
+	selectionGeneration uint64
+	// ThreadModelGoroutines or ThreadModelThreads.
+	threadModel string
+	// Whether list-thread-groups groups goroutines by start function
+	// (set kabuta group-goroutines on).
+	groupGoroutines bool
+	// IDs of goroutine groups (g1, g2, etc.) by start function.
+	goroutineGroupIDs map[string]string
+	// Whether system goroutines are listed (see EnvKabutaShowSystemGoroutines).
+	showSystemGoroutines bool
+	// Goroutines not to list (see EnvKabutaHiddenGoroutines), if any.
+	hiddenGoroutinesRegexp *regexp.Regexp
+	// Whether the frontend has listed threads, after which it is
+	// notified of threads created and exited (see notifyThreadChanges()).
+	threadsTracked bool
+	// IDs of the threads the frontend has been shown or notified of
+	// with =thread-created.
+	knownThreadIDs map[int64]bool
+	// Variable objects by name (see varobj).
+	varobjs map[string]*varobj
+	// Number of the last varobj named by kabuta (var1, var2, etc.)
+	lastVarobjNo int
+	// Number of the last GDB value history entry ($1, $2, etc.)
+	// reported to the frontend (e.g., return value after exec-finish).
+	resultVarNo int
 }
 
 // readLoop receives data from the frontend (by reading from stdin)
-// and sends this information on the frontendChannel.
+// and sends this information on the frontendChannel, one command
+// per line. Commands keep being accepted while the target runs,
+// so that, e.g., exec-interrupt can get to frontendWriteLoop.
 func (s *kabuta) frontendReadLoop() {
 	defer wg.Done()
-	b := make([]byte, 1024)
-	for {
-		n, err := os.Stdin.Read(b)
-		if err != nil {
-			panic(err)
-		}
-		if n > 0 {
-			str := strings.TrimSpace(string(b[0:n]))
-			//			s.log("RECEIVED %d bytes FROM FRONTEND:\n----------------\n[%s]\n------------------", n, str)
-			strArr := strings.Split(str, "\n")
-			for _, str2 := range strArr {
-				str2 = strings.TrimSpace(str2)
-				if str2 == "" {
-					continue
-				}
-				s.frontendChannel <- str2
-			}
-			b = make([]byte, 1024)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		str := strings.TrimSpace(scanner.Text())
+		if str == "" {
+			continue
 		}
+		s.frontendChannel <- str
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
 	}
+	// Like GDB, quit when the frontend goes away.
+	s.log("Frontend closed stdin, exiting.")
+	os.Exit(0)
 }
 
 func (k *kabuta) dlvReadLoop(stdout bool) {
@@ -120,34 +172,48 @@ func (k *kabuta) dlvReadLoop(stdout bool) {
 }
 
 // frontendWriteLoop continually checks for messages coming on
-// frontendChannel and processes them.
+// frontendChannel (and internalChannel) and processes them.
 func (k *kabuta) frontendWriteLoop() {
 	defer wg.Done()
+	prompt := true
 	for {
-		k.writeToFrontend(GdbPrompt)
-		str := <-k.frontendChannel
-		k.log("RECEIVED> %s", str)
-		//		k.log("RECEIVED %d bytes FROM FRONTEND CHANNEL:\n---------------------\n[%s]\n---------------------", len(str), str)
-		req := newFrontendRequest(k, str)
-		req.process()
+		if prompt {
+			k.writeToFrontend(GdbPrompt)
+		}
+		select {
+		case str := <-k.frontendChannel:
+			k.log("RECEIVED> %s", str)
+			//		k.log("RECEIVED %d bytes FROM FRONTEND CHANNEL:\n---------------------\n[%s]\n---------------------", len(str), str)
+			req := newFrontendRequest(k, str)
+			req.process()
+			prompt = true
+		case req := <-k.internalChannel:
+			k.log("INTERNAL> %s", req.rawCmd)
+			req.process()
+			prompt = false
+		}
 	}
 }
 
 // log logs the message (f formats can be used).
 func (s *kabuta) log(str string, args ...interface{}) {
 	msg := f(str+"\n", args...)
-	_, err = s.logFile.WriteString(msg)
+	_, err := s.logFile.WriteString(msg)
 	if err != nil {
 		panic(err)
 	}
 	// Send info to debug stream too:
 	// https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Stream-Records.html#GDB_002fMI-Stream-Records
-	s.writeToFrontend("&" + msg)
+	s.frontendLock.Lock()
+	defer s.frontendLock.Unlock()
+	os.Stdout.WriteString("&" + miQuote(msg) + "\n")
 }
 
 // writeToFrontend sends the string to stdout.
 func (s *kabuta) writeToFrontend(str string) {
-	n, err := os.Stdout.WriteString(str)
+	s.frontendLock.Lock()
+	_, err := os.Stdout.WriteString(str)
+	s.frontendLock.Unlock()
 	if err != nil {
 		s.log("Error sending %s: %v\n", str, err)
 		panic(err)
@@ -155,6 +221,35 @@ func (s *kabuta) writeToFrontend(str string) {
 	s.log("SENT>%s", str)
 }
 
+// sendConsoleStreamRecord sends the message (f formats can be used)
+// to the frontend as a console stream record, i.e., something
+// the frontend displays to the user as CLI output.
+func (k *kabuta) sendConsoleStreamRecord(s string, args ...interface{}) {
+	k.writeToFrontend("~" + miQuote(f(s, args...)) + "\n")
+}
+
+// sendAsyncRecord sends an exec async record (such as *stopped) to
+// the frontend, followed by a prompt, as GDB does.
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Async-Records.html
+func (k *kabuta) sendAsyncRecord(class string, results string) {
+	record := "*" + class
+	if results != "" {
+		record += "," + results
+	}
+	k.writeToFrontend(record + "\n" + GdbPrompt)
+}
+
+// sendNotifyRecord sends a notify async record (such as =breakpoint-modified)
+// to the frontend.
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Async-Records.html
+func (k *kabuta) sendNotifyRecord(class string, results string) {
+	record := "=" + class
+	if results != "" {
+		record += "," + results
+	}
+	k.writeToFrontend(record + "\n")
+}
+
 // frontendRequest holds information about the frontend request that
 // will be needed for the response.
 type frontendRequest struct {
@@ -163,78 +258,9 @@ type frontendRequest struct {
 	rawCmd string
 	gdbCmd *gdbCmd
 	token  string
-}
-
-const (
-	breakpointTypeLinenum = iota
-	breakpointTypeOffset
-	breakpointTypeFilenameLinenum
-	breakpointTypeFunction
-	breakpointTypeFunctionLabel
-	breakpointTypeFilenameFunction
-	breakpointTypeLabel
-)
-
-// https://sourceware.org/gdb/onlinedocs/gdb/Linespec-Locations.html#Linespec-Locations
-type breakpoint struct {
-	rawLocation    string
-	breakpointType int
-	fileName       string
-	lineNo         int
-	function       string
-	dlvBreakpoint  *api.Breakpoint
-}
-
-func (bp breakpoint) String() string {
-	switch bp.breakpointType {
-	case breakpointTypeFilenameLinenum:
-		return f("Breakpoint at %s:%d, Delve breakpoint: %+v", bp.fileName, bp.lineNo, *bp.dlvBreakpoint)
-	case breakpointTypeFunction:
-		return f("Breakpoint at function %s, Delve breakpoint: %+v", bp.function, *bp.dlvBreakpoint)
-	default:
-		return "Impossible breakpoint"
-	}
-}
-
-// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Breakpoint-Commands.html
-func newBreakpoint(rawLocation string) (*breakpoint, error) {
-	bp := breakpoint{rawLocation: rawLocation, dlvBreakpoint: &api.Breakpoint{}}
-	elts := strings.Split(rawLocation, ":")
-	if len(elts) == 1 {
-		elt := elts[0]
-		// Offset not supported
-		if strings.HasPrefix(elt, "+") || strings.HasPrefix(elt, "-") {
-			return nil, NewError("Breakpoint location type not supported: %s", rawLocation)
-		}
-		_, err = strconv.ParseInt(elt, 10, 64)
-		if err == nil {
-			// This is a line number... Not supported.
-			return nil, NewError("Breakpoint location type not supported: %s", rawLocation)
-		}
-		// Assume it's function
-		bp.breakpointType = breakpointTypeFunction
-		bp.function = elts[0]
-		bp.dlvBreakpoint.FunctionName = elts[0]
-	} else {
-		if len(elts) != 2 {
-			return nil, NewError("Breakpoint location type not supported: %s", rawLocation)
-		}
-		fileName := elts[0]
-		if _, err := os.Stat(fileName); os.IsNotExist(err) {
-			return nil, NewError("Breakpoint location type not supported: %s (assumed %s is a file but it doesn't exist)", rawLocation, fileName)
-		}
-		bp.fileName = fileName
-		bp.dlvBreakpoint.File = fileName
-
-		lineNo, err := strconv.ParseInt(elts[1], 10, 32)
-		if err != nil {
-			return nil, NewError("Breakpoint location type not supported: %s (assumed %s is a line number, but cannot parse: %s", rawLocation, elts[1], err)
-		}
-		bp.breakpointType = breakpointTypeFilenameLinenum
-		bp.lineNo = int(lineNo)
-		bp.dlvBreakpoint.Line = bp.lineNo
-	}
-	return &bp, nil
+	// Whether the response goes to the console stream rather than
+	// being a result record (for requests on internalChannel).
+	console bool
 }
 
 // newFrontendRequest creates a new frontendRequest
@@ -247,12 +273,12 @@ func newFrontendRequest(k *kabuta, command string) *frontendRequest {
 // functions for processing this MI command.
 func (r *frontendRequest) processCmd(matches [][]string, isMiCmd bool) {
 	r.token = matches[0][1]
-	cmdLine := matches[0][2]
-	cmdElts := strings.Split(cmdLine, " ")
+	cmdLine := strings.TrimSpace(matches[0][2])
+	cmdElts := strings.SplitN(cmdLine, " ", 2)
 	r.gdbCmd = &gdbCmd{cmd: cmdElts[0], frontendRequest: r, isMiCmd: isMiCmd}
 	if len(cmdElts) > 1 {
-		r.gdbCmd.args = cmdElts[1:]
-		r.gdbCmd.argsStr = strings.Join(r.gdbCmd.args, " ")
+		r.gdbCmd.argsStr = strings.TrimSpace(cmdElts[1])
+		r.gdbCmd.args = splitArgs(r.gdbCmd.argsStr)
 	}
 	r.gdbCmd.process()
 }
@@ -292,7 +318,7 @@ func Run() error {
 	}
 
 	k := &kabuta{}
-	k.loadConfig := &api.LoadConfig{FollowPointers: true, MaxVariableRecurse: 10, MaxStringLen: 1024, MaxArrayValues: 1024, MaxStructFields: -1}
+	k.loadConfig = &api.LoadConfig{FollowPointers: true, MaxVariableRecurse: 10, MaxStringLen: 1024, MaxArrayValues: 1024, MaxStructFields: -1}
 
 	// Start logging.
 	logFileName := conf[EnvKabutaLogFile]
@@ -334,6 +360,30 @@ func Run() error {
 		return NewError("Expected DLV port specified by %s to be integer, got %s: %s", EnvKabutaDlvPort, dlvPortStr, err)
 	}
 
+	// Thread model
+	k.threadModel = conf[EnvKabutaThreadModel]
+	if k.threadModel == "" {
+		k.threadModel = ThreadModelGoroutines
+	}
+	if k.threadModel != ThreadModelGoroutines && k.threadModel != ThreadModelThreads {
+		return NewError("Expected thread model specified by %s to be %s or %s, got %s", EnvKabutaThreadModel, ThreadModelGoroutines, ThreadModelThreads, k.threadModel)
+	}
+
+	// Goroutine filter
+	k.showSystemGoroutines = true
+	if show := conf[EnvKabutaShowSystemGoroutines]; show != "" {
+		k.showSystemGoroutines, err = parseOnOff(show)
+		if err != nil {
+			return NewError("Expected %s to be on or off, got %s", EnvKabutaShowSystemGoroutines, show)
+		}
+	}
+	if hidden := conf[EnvKabutaHiddenGoroutines]; hidden != "" {
+		k.hiddenGoroutinesRegexp, err = regexp.Compile(hidden)
+		if err != nil {
+			return NewError("Invalid regular expression specified by %s: %s", EnvKabutaHiddenGoroutines, err)
+		}
+	}
+
 	// Set path
 	kabutaPath := conf[EnvKabutaPath]
 	if kabutaPath != "" {
@@ -343,11 +393,17 @@ func Run() error {
 		k.log("Added %s %s to PATH %s, new value %s", EnvKabutaPath, kabutaPath, oldPath, newPath)
 	}
 
-	k.frontendChannel = make(chan string)
+	// Buffered so that frontendReadLoop keeps reading while a command is processed.
+	k.frontendChannel = make(chan string, 64)
+	k.internalChannel = make(chan *frontendRequest, 64)
 	k.dlvChannel = make(chan string)
 	k.miCmdRegexp = regexp.MustCompile(RegexpMiCmd)
 	k.cliCmdRegexp = regexp.MustCompile(RegexpCliCmd)
 	k.debugBinaryToPackageDir = make(map[string]string)
+	k.breakpoints = make(map[int]*breakpoint)
+	k.varobjs = make(map[string]*varobj)
+	k.goroutineGroupIDs = make(map[string]string)
+	k.knownThreadIDs = make(map[int64]bool)
 	wg.Add(2)
 
 	args := os.Args[1:]
@@ -1,12 +1,1753 @@
-package test
+package kabuta
 
-func TestMakeGdbResult() {
-	data := struct {
-		Title string
-		Users []*User
+import (
+	"flag"
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDelve serves the part of Delve's JSON-RPC API the tests need.
+type fakeDelve struct {
+	// Guards breakpoints, as requests are served concurrently.
+	lock        sync.Mutex
+	breakpoints map[int]*api.Breakpoint
+	// State in which the target stops when resumed, once the test
+	// sends on resume (if set), and the commands resuming it.
+	stopState api.DebuggerState
+	resume    chan bool
+	commands  []string
+	// Stacks of the goroutines by ID, and the number of Stacktrace
+	// calls loading their variables.
+	stacks          map[int64][]api.Stackframe
+	fullStacktraces int
+	threads         []*api.Thread
+	// OS thread Delve has selected.
+	currentThreadID int
+	sources         []string
+	goroutines      []*api.Goroutine
+	// Locations FindLocation finds by expression, lines of FILE:LINE
+	// expressions with code, and the expressions it was given.
+	locations     map[string][]api.Location
+	codeLines     map[int]bool
+	locationExprs []string
+	// Number of ListGoroutines calls.
+	listGoroutinesCalls int
+	pid                 int
+	// What Eval returns, and the expressions and scopes it was given.
+	evalResult api.Variable
+	evalExprs  []string
+	evalScopes []api.EvalScope
+	// Scopes Set was given.
+	setScopes []api.EvalScope
+}
+
+func (d *fakeDelve) ListGoroutines(in rpc2.ListGoroutinesIn, out *rpc2.ListGoroutinesOut) error {
+	d.listGoroutinesCalls++
+	out.Nextg = -1
+	if in.GroupBy != api.GoroutineStartLoc {
+		out.Goroutines = d.goroutines
+		return nil
+	}
+	// Groups by start function, in the order they first appear.
+	var names []string
+	members := make(map[string][]*api.Goroutine)
+	for _, g := range d.goroutines {
+		name := functionName(g.StartLoc)
+		if members[name] == nil {
+			names = append(names, name)
+		}
+		members[name] = append(members[name], g)
+	}
+	for _, name := range names {
+		group := members[name]
+		out.Groups = append(out.Groups, api.GoroutineGroup{Name: name, Offset: len(out.Goroutines), Count: len(group), Total: len(group)})
+		out.Goroutines = append(out.Goroutines, group...)
+	}
+	return nil
+}
+
+func (d *fakeDelve) ProcessPid(in rpc2.ProcessPidIn, out *rpc2.ProcessPidOut) error {
+	out.Pid = d.pid
+	return nil
+}
+
+func (d *fakeDelve) Eval(in rpc2.EvalIn, out *rpc2.EvalOut) error {
+	d.evalExprs = append(d.evalExprs, in.Expr)
+	d.evalScopes = append(d.evalScopes, in.Scope)
+	v := d.evalResult
+	out.Variable = &v
+	return nil
+}
+
+func (d *fakeDelve) Set(in rpc2.SetIn, out *rpc2.SetOut) error {
+	d.setScopes = append(d.setScopes, in.Scope)
+	return nil
+}
+
+func (d *fakeDelve) ListSources(in rpc2.ListSourcesIn, out *rpc2.ListSourcesOut) error {
+	out.Sources = d.sources
+	return nil
+}
+
+func (d *fakeDelve) FindLocation(in rpc2.FindLocationIn, out *rpc2.FindLocationOut) error {
+	d.locationExprs = append(d.locationExprs, in.Loc)
+	if locs, ok := d.locations[in.Loc]; ok {
+		out.Locations = locs
+		return nil
+	}
+	if i := strings.LastIndex(in.Loc, ":"); i >= 0 {
+		line, err := strconv.Atoi(in.Loc[i+1:])
+		if err == nil && d.codeLines[line] {
+			out.Locations = []api.Location{{File: in.Loc[:i], Line: line}}
+			return nil
+		}
+	}
+	return NewError("location %s not found", in.Loc)
+}
+
+func (d *fakeDelve) CreateBreakpoint(in rpc2.CreateBreakpointIn, out *rpc2.CreateBreakpointOut) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.breakpoints == nil {
+		d.breakpoints = make(map[int]*api.Breakpoint)
+	}
+	bp := in.Breakpoint
+	bp.ID = len(d.breakpoints) + 1
+	d.breakpoints[bp.ID] = &bp
+	out.Breakpoint = bp
+	return nil
+}
+
+func (d *fakeDelve) CreateWatchpoint(in rpc2.CreateWatchpointIn, out *rpc2.CreateWatchpointOut) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.breakpoints == nil {
+		d.breakpoints = make(map[int]*api.Breakpoint)
+	}
+	bp := &api.Breakpoint{ID: len(d.breakpoints) + 1, WatchExpr: in.Expr, WatchType: in.Type}
+	d.breakpoints[bp.ID] = bp
+	dlvBp := *bp
+	out.Breakpoint = &dlvBp
+	return nil
+}
+
+func (d *fakeDelve) ListThreads(in rpc2.ListThreadsIn, out *rpc2.ListThreadsOut) error {
+	out.Threads = d.threads
+	return nil
+}
+
+func (d *fakeDelve) Command(cmd api.DebuggerCommand, out *rpc2.CommandOut) error {
+	switch cmd.Name {
+	case api.Continue, api.Next, api.Step, api.StepOut, api.StepInstruction, api.NextInstruction:
+		if d.resume != nil {
+			<-d.resume
+		}
+		d.commands = append(d.commands, cmd.Name)
+		out.State = d.stopState
+		return nil
+	case api.SwitchThread:
+	default:
+		return NewError("unsupported command %s", cmd.Name)
+	}
+	for _, t := range d.threads {
+		if t.ID == cmd.ThreadID {
+			d.currentThreadID = t.ID
+			out.State = api.DebuggerState{CurrentThread: t}
+			return nil
+		}
+	}
+	return NewError("unknown thread %d", cmd.ThreadID)
+}
+
+func (d *fakeDelve) Stacktrace(in rpc2.StacktraceIn, out *rpc2.StacktraceOut) error {
+	if in.Full {
+		d.fullStacktraces++
+	}
+	frames, ok := d.stacks[in.Id]
+	if !ok {
+		return NewError("unknown goroutine %d", in.Id)
+	}
+	if len(frames) > in.Depth+1 {
+		frames = frames[:in.Depth+1]
+	}
+	out.Locations = frames
+	return nil
+}
+
+func (d *fakeDelve) GetBreakpoint(in rpc2.GetBreakpointIn, out *rpc2.GetBreakpointOut) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, bp := range d.breakpoints {
+		if (in.Name == "" && bp.ID == in.Id) || (in.Name != "" && bp.Name == in.Name) {
+			out.Breakpoint = *bp
+			return nil
+		}
+	}
+	return NewError("no breakpoint with id %d or name %q", in.Id, in.Name)
+}
+
+func (d *fakeDelve) AmendBreakpoint(in rpc2.AmendBreakpointIn, out *rpc2.AmendBreakpointOut) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	bp, ok := d.breakpoints[in.Breakpoint.ID]
+	if !ok {
+		return NewError("no breakpoint with id %d", in.Breakpoint.ID)
+	}
+	hits := bp.TotalHitCount
+	*bp = in.Breakpoint
+	bp.TotalHitCount = hits
+	return nil
+}
+
+func (d *fakeDelve) ClearBreakpoint(in rpc2.ClearBreakpointIn, out *rpc2.ClearBreakpointOut) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	bp, ok := d.breakpoints[in.Id]
+	if !ok {
+		return NewError("no breakpoint with id %d", in.Id)
+	}
+	delete(d.breakpoints, in.Id)
+	out.Breakpoint = bp
+	return nil
+}
+
+func (d *fakeDelve) ListBreakpoints(in rpc2.ListBreakpointsIn, out *rpc2.ListBreakpointsOut) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, bp := range d.breakpoints {
+		dlvBp := *bp
+		out.Breakpoints = append(out.Breakpoints, &dlvBp)
+	}
+	return nil
+}
+
+// newTestKabuta returns kabuta talking to the fake Delve.
+func newTestKabuta(t *testing.T, d *fakeDelve) *kabuta {
+	server := rpc.NewServer()
+	err := server.RegisterName("RPCServer", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, serverConn := net.Pipe()
+	go server.ServeCodec(jsonrpc.NewServerCodec(serverConn))
+	logFile, err := os.Create(filepath.Join(t.TempDir(), "kabuta.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &kabuta{
+		dlvRpcClient:   jsonrpc.NewClient(clientConn),
+		logFile:        logFile,
+		breakpoints:    make(map[int]*breakpoint),
+		varobjs:        make(map[string]*varobj),
+		knownThreadIDs: make(map[int64]bool),
+		threadModel:    ThreadModelGoroutines,
+		loadConfig:     &api.LoadConfig{FollowPointers: true, MaxVariableRecurse: 10, MaxStringLen: 1024, MaxArrayValues: 1024, MaxStructFields: -1},
+	}
+	t.Cleanup(func() {
+		k.dlvRpcClient.Close()
+		logFile.Close()
+	})
+	return k
+}
+
+// testCmd returns the MI command with the arguments, as process() would.
+func (k *kabuta) testCmd(cmd string, args ...string) *gdbCmd {
+	flagSet := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	return &gdbCmd{
+		isMiCmd:         true,
+		cmd:             cmd,
+		args:            args,
+		argsStr:         strings.Join(args, " "),
+		frontendRequest: &frontendRequest{kabuta: k},
+		flagSet:         flagSet,
+	}
+}
+
+// sentRecords returns the records sent to the frontend starting with
+// the prefix, as logged.
+func sentRecords(t *testing.T, k *kabuta, prefix string) []string {
+	log, err := ioutil.ReadFile(k.logFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for _, line := range strings.Split(string(log), "\n") {
+		if strings.HasPrefix(line, "SENT>"+prefix) {
+			records = append(records, strings.TrimPrefix(line, "SENT>"))
+		}
+	}
+	return records
+}
+
+// processWithGlobalOptions runs the command's method the way process()
+// does, within the selection given by --thread and --frame.
+func processWithGlobalOptions(t *testing.T, c *gdbCmd, method func(*gdbCmd) gdbMiResponse) gdbMiResponse {
+	restoreSelection, resp := c.applyGlobalOptions()
+	if resp.err != nil {
+		t.Fatalf("%s: %s", c, resp.err)
+	}
+	resp = method(c)
+	restoreSelection()
+	return resp
+}
+
+func TestMiQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", `""`},
+		{"main.main", `"main.main"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\go\src`, `"C:\\go\\src"`},
+		{`\"`, `"\\\""`},
+		{"line\n", `"line\n"`},
+		{"a\tb\r\n", `"a\tb\r\n"`},
+		{"héllo", `"héllo"`},
+	}
+	for _, test := range tests {
+		if got := miQuote(test.s); got != test.want {
+			t.Errorf("miQuote(%q) = %s, want %s", test.s, got, test.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		argsStr string
+		want    []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"main.go:12", []string{"main.go:12"}},
+		{"-t  -c\tx main.go:12", []string{"-t", "-c", "x", "main.go:12"}},
+		{`-c "i == 1" main.go:12`, []string{"-c", "i == 1", "main.go:12"}},
+		{`"" x`, []string{"", "x"}},
+		{`x ""`, []string{"x", ""}},
+		{`"say \"hi\""`, []string{`say "hi"`}},
+		{`"C:\\go\\src"`, []string{`C:\go\src`}},
+		{`"a\tb\r\n"`, []string{"a\tb\r\n"}},
+		{`pre"fix and"post`, []string{"prefix andpost"}},
+		{`C:\go`, []string{`C:\go`}},
+	}
+	for _, test := range tests {
+		if got := splitArgs(test.argsStr); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", test.argsStr, got, test.want)
+		}
+	}
+}
+
+// TestSplitArgsMiQuote checks that arguments quoted as GDB/MI c-strings
+// are split back into the original strings.
+func TestSplitArgsMiQuote(t *testing.T) {
+	for _, s := range []string{"", "x", "i == 1", `m["a"]`, `C:\go\src`, "a\tb\r\nc"} {
+		got := splitArgs(miQuote(s) + " " + miQuote(s))
+		if want := []string{s, s}; !reflect.DeepEqual(got, want) {
+			t.Errorf("splitArgs(miQuote(%q) twice) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+// testLocation returns the location of a frame in the function.
+func testLocation(function string, line int) api.Location {
+	return api.Location{PC: 0x401000, File: "/src/cli/main.go", Line: line, Function: &api.Function{Name_: function}}
+}
+
+// testStoppedThread returns the OS thread running goroutine 1, stopped
+// at the location.
+func testStoppedThread(loc api.Location) *api.Thread {
+	return &api.Thread{ID: 7, GoroutineID: 1, PC: loc.PC, File: loc.File, Line: loc.Line, Function: loc.Function}
+}
+
+// TestExecContinue checks that -exec-continue answers ^running, refuses
+// to resume the target again until it stops, and reports the breakpoint
+// hit with a *stopped record.
+func TestExecContinue(t *testing.T) {
+	d := &fakeDelve{}
+	k := newTestKabuta(t, d)
+	if resp := k.testCmd("break-insert", "*0x401000").BreakInsert(); resp.err != nil {
+		t.Fatalf("-break-insert: %s", resp.err)
+	}
+	thread := testStoppedThread(testLocation("main.main", 12))
+	thread.Breakpoint = k.breakpoints[1].dlvBreakpoint
+	d.stopState = api.DebuggerState{CurrentThread: thread}
+
+	resp := k.testCmd("exec-continue").ExecContinue()
+	if resp.err != nil || resp.state != "running" || resp.dlvCommand != api.Continue {
+		t.Fatalf("-exec-continue = %+v, want running with continue", resp)
+	}
+	if resp := k.testCmd("exec-continue").ExecContinue(); resp.err == nil {
+		t.Errorf("-exec-continue while running = %+v, want an error", resp)
+	}
+	k.execute(resp.dlvCommand)
+	if k.isRunning() {
+		t.Error("target running after it stopped")
+	}
+	want := []string{f(`*stopped,reason="breakpoint-hit",disp="keep",bkptno="1",`+
+		`frame={addr="0x0000000000401000",func="main.main",file="main.go",fullname="/src/cli/main.go",line="12",arch="%s",args=[]},`+
+		`thread-id="1",stopped-threads="all"`, gdbArch())}
+	if got := sentRecords(t, k, "*stopped"); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
+
+// TestStoppedResultsExited checks the *stopped records of a target
+// that exited.
+func TestStoppedResultsExited(t *testing.T) {
+	k := newTestKabuta(t, &fakeDelve{})
+	tests := []struct {
+		exitStatus int
+		want       string
+	}{
+		{0, `reason="exited-normally"`},
+		{3, `reason="exited",exit-code="03"`},
+		{10, `reason="exited",exit-code="12"`},
+	}
+	for _, test := range tests {
+		state := &api.DebuggerState{Exited: true, ExitStatus: test.exitStatus}
+		if got := k.stoppedResults(state, api.Continue); got != test.want {
+			t.Errorf("stoppedResults(exit status %d) = %s, want %s", test.exitStatus, got, test.want)
+		}
+	}
+}
+
+// TestExecStepping checks that stepping commands run the Delve command
+// and report the end of the stepping range, and that reverse stepping
+// is refused.
+func TestExecStepping(t *testing.T) {
+	tests := []struct {
+		cmd        string
+		method     func(*gdbCmd) gdbMiResponse
+		dlvCommand string
 	}{
-		title,
-		users,
+		{"exec-next", (*gdbCmd).ExecNext, api.Next},
+		{"exec-step", (*gdbCmd).ExecStep, api.Step},
+		{"exec-next-instruction", (*gdbCmd).ExecNextInstruction, api.NextInstruction},
+		{"exec-step-instruction", (*gdbCmd).ExecStepInstruction, api.StepInstruction},
+	}
+	for _, test := range tests {
+		d := &fakeDelve{stopState: api.DebuggerState{CurrentThread: testStoppedThread(testLocation("main.main", 13))}}
+		k := newTestKabuta(t, d)
+		if resp := test.method(k.testCmd(test.cmd, "--reverse")); resp.err == nil {
+			t.Errorf("-%s --reverse = %+v, want an error", test.cmd, resp)
+		}
+		resp := test.method(k.testCmd(test.cmd))
+		if resp.err != nil || resp.state != "running" || resp.dlvCommand != test.dlvCommand {
+			t.Fatalf("-%s = %+v, want running with %s", test.cmd, resp, test.dlvCommand)
+		}
+		k.execute(resp.dlvCommand)
+		if !reflect.DeepEqual(d.commands, []string{test.dlvCommand}) {
+			t.Errorf("-%s ran %q, want %s", test.cmd, d.commands, test.dlvCommand)
+		}
+		got := sentRecords(t, k, "*stopped")
+		if len(got) != 1 || !strings.HasPrefix(got[0], `*stopped,reason="end-stepping-range",frame={`) || !strings.Contains(got[0], `line="13"`) {
+			t.Errorf("-%s records = %q, want end-stepping-range at line 13", test.cmd, got)
+		}
+	}
+}
+
+// TestExecFinish checks that -exec-finish reports the values returned
+// by the function, numbering them as GDB's value history does.
+func TestExecFinish(t *testing.T) {
+	thread := testStoppedThread(testLocation("main.main", 14))
+	d := &fakeDelve{stopState: api.DebuggerState{CurrentThread: thread}}
+	k := newTestKabuta(t, d)
+	returnValues := [][]api.Variable{
+		{{Kind: reflect.Int, Value: "42"}},
+		{{Kind: reflect.Int, Value: "1"}, {Kind: reflect.Int, Value: "2"}},
+	}
+	wants := []string{`gdb-result-var="$1",return-value="42",`, `gdb-result-var="$2",return-value="(1, 2)",`}
+	for i, values := range returnValues {
+		thread.ReturnValues = values
+		resp := k.testCmd("exec-finish").ExecFinish()
+		if resp.err != nil || resp.dlvCommand != api.StepOut {
+			t.Fatalf("-exec-finish = %+v, want running with %s", resp, api.StepOut)
+		}
+		k.execute(resp.dlvCommand)
+		got := sentRecords(t, k, "*stopped")
+		if len(got) != i+1 || !strings.HasPrefix(got[i], `*stopped,reason="function-finished",`) || !strings.Contains(got[i], wants[i]) {
+			t.Errorf("records = %q, want function-finished with %s", got, wants[i])
+		}
+	}
+}
+
+// TestBreakAfterHitBreakpoint checks that -break-after ignores the next
+// hits of a breakpoint that has already been hit.
+func TestBreakAfterHitBreakpoint(t *testing.T) {
+	d := &fakeDelve{breakpoints: map[int]*api.Breakpoint{
+		1: {ID: 1, File: "/src/cli/main.go", Line: 12, TotalHitCount: 3},
+	}}
+	k := newTestKabuta(t, d)
+	k.addBreakpoint(&breakpoint{rawLocation: "main.go:12", breakpointType: breakpointTypeFilenameLinenum, dlvBreakpoint: &api.Breakpoint{ID: 1}})
+
+	resp := k.testCmd("break-after", "1", "2").BreakAfter()
+	if resp.err != nil {
+		t.Fatalf("-break-after 1 2: %s", resp.err)
+	}
+	if got, want := d.breakpoints[1].HitCond, "> 5"; got != want {
+		t.Errorf("Delve hit condition = %q, want %q", got, want)
+	}
+	if got := k.breakpoints[1].ignoreCount; got != 2 {
+		t.Errorf("ignore count = %d, want 2", got)
+	}
+
+	resp = k.testCmd("break-after", "1", "0").BreakAfter()
+	if resp.err != nil {
+		t.Fatalf("-break-after 1 0: %s", resp.err)
+	}
+	if got := d.breakpoints[1].HitCond; got != "" {
+		t.Errorf("Delve hit condition = %q after -break-after 1 0, want none", got)
+	}
+}
+
+// TestRunBreakpointCommands checks that breakpoint commands after one
+// resuming the target, even with arguments, are not queued.
+func TestRunBreakpointCommands(t *testing.T) {
+	k := newTestKabuta(t, &fakeDelve{})
+	k.internalChannel = make(chan *frontendRequest, 64)
+	k.runBreakpointCommands([]string{"print x", "  continue 2", "print y"})
+	close(k.internalChannel)
+	var got []string
+	for req := range k.internalChannel {
+		if !req.console {
+			t.Errorf("%q not run as a console command", req.rawCmd)
+		}
+		got = append(got, req.rawCmd)
+	}
+	want := []string{"print x", "continue 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %q, want %q", got, want)
+	}
+}
+
+// testStack returns a fake Delve with a stack of three frames for goroutine 1.
+func testStack() *fakeDelve {
+	return &fakeDelve{stacks: map[int64][]api.Stackframe{
+		1: {
+			{Location: testLocation("main.inner", 40)},
+			{Location: testLocation("main.outer", 30)},
+			{Location: testLocation("main.main", 20)},
+		},
+	}}
+}
+
+// TestStackListFrames checks that frames are listed from Delve's stack
+// trace, in the range given if any.
+func TestStackListFrames(t *testing.T) {
+	k := newTestKabuta(t, testStack())
+	k.selectedGoroutineID = 1
+	frame := func(level int, function string, line int) string {
+		return f(`frame={level="%d",addr="0x0000000000401000",func="%s",file="main.go",fullname="/src/cli/main.go",line="%d",arch="%s"}`,
+			level, function, line, gdbArch())
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "stack=[" + frame(0, "main.inner", 40) + "," + frame(1, "main.outer", 30) + "," + frame(2, "main.main", 20) + "]"},
+		{[]string{"--no-frame-filters", "1", "1"}, "stack=[" + frame(1, "main.outer", 30) + "]"},
+		{[]string{"1", "-1"}, "stack=[" + frame(1, "main.outer", 30) + "," + frame(2, "main.main", 20) + "]"},
+	}
+	for _, test := range tests {
+		resp := k.testCmd("stack-list-frames", test.args...).StackListFrames()
+		if resp.err != nil {
+			t.Errorf("-stack-list-frames %s: %s", test.args, resp.err)
+		} else if resp.results != test.want {
+			t.Errorf("-stack-list-frames %s = %s, want %s", test.args, resp.results, test.want)
+		}
+	}
+	for _, args := range [][]string{{"3", "4"}, {"1"}, {"x", "2"}} {
+		if resp := k.testCmd("stack-list-frames", args...).StackListFrames(); resp.err == nil {
+			t.Errorf("-stack-list-frames %s = %s, want an error", args, resp.results)
+		}
+	}
+}
+
+// TestStackInfoDepth checks that the depth is that of the stack,
+// up to the maximum given.
+func TestStackInfoDepth(t *testing.T) {
+	k := newTestKabuta(t, testStack())
+	k.selectedGoroutineID = 1
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, `depth="3"`},
+		{[]string{"2"}, `depth="2"`},
+		{[]string{"10"}, `depth="3"`},
+	}
+	for _, test := range tests {
+		resp := k.testCmd("stack-info-depth", test.args...).StackInfoDepth()
+		if resp.err != nil || resp.results != test.want {
+			t.Errorf("-stack-info-depth %s = %+v, want %s", test.args, resp, test.want)
+		}
+	}
+}
+
+// TestStackSelectFrame checks that -stack-select-frame changes the frame
+// -stack-info-frame reports, without notifying the frontend.
+func TestStackSelectFrame(t *testing.T) {
+	k := newTestKabuta(t, testStack())
+	k.selectedGoroutineID = 1
+	resp := k.testCmd("stack-select-frame", "1").StackSelectFrame()
+	if resp.err != nil {
+		t.Fatal(resp.err)
+	}
+	resp = k.testCmd("stack-info-frame").StackInfoFrame()
+	want := f(`frame={level="1",%s}`, miLocation(testLocation("main.outer", 30)))
+	if resp.err != nil || resp.results != want {
+		t.Errorf("-stack-info-frame = %+v, want %s", resp, want)
+	}
+	if resp := k.testCmd("stack-select-frame", "3").StackSelectFrame(); resp.err == nil {
+		t.Error("-stack-select-frame 3 succeeded past the outermost frame")
+	}
+	if k.selectedFrame != 1 {
+		t.Errorf("selected frame = %d after a failed selection, want 1", k.selectedFrame)
+	}
+	if records := sentRecords(t, k, "=thread-selected"); len(records) > 0 {
+		t.Errorf("-stack-select-frame sent %s", records)
+	}
+}
+
+// TestFrameUpDown checks that the frame, up and down CLI commands
+// move the selection within the stack and notify the frontend of changes.
+func TestFrameUpDown(t *testing.T) {
+	k := newTestKabuta(t, testStack())
+	k.selectedGoroutineID = 1
+	selected := func(level int, function string, line int) string {
+		return f(`=thread-selected,id="1",frame={level="%d",%s}`, level, miFrame(testLocation(function, line)))
+	}
+	tests := []struct {
+		cmd     func(*gdbCmd) gdbMiResponse
+		args    []string
+		level   int
+		wantErr bool
+	}{
+		{(*gdbCmd).Down, nil, 0, true},
+		{(*gdbCmd).Up, nil, 1, false},
+		{(*gdbCmd).Up, []string{"5"}, 2, false},
+		{(*gdbCmd).Up, nil, 2, true},
+		{(*gdbCmd).Frame, nil, 2, false},
+		{(*gdbCmd).Down, []string{"2"}, 0, false},
+		{(*gdbCmd).Frame, []string{"level", "1"}, 1, false},
+		{(*gdbCmd).Frame, []string{"3"}, 1, true},
+	}
+	for i, test := range tests {
+		c := k.testCmd("frame", test.args...)
+		c.isMiCmd = false
+		resp := test.cmd(c)
+		if (resp.err != nil) != test.wantErr {
+			t.Errorf("command %d: error = %v, want error %t", i, resp.err, test.wantErr)
+		}
+		if k.selectedFrame != test.level {
+			t.Errorf("command %d: selected frame = %d, want %d", i, k.selectedFrame, test.level)
+		}
+	}
+	want := []string{
+		selected(1, "main.outer", 30),
+		selected(2, "main.main", 20),
+		selected(0, "main.inner", 40),
+		selected(1, "main.outer", 30),
+	}
+	if records := sentRecords(t, k, "=thread-selected"); !reflect.DeepEqual(records, want) {
+		t.Errorf("sent %q, want %q", records, want)
+	}
+}
+
+// TestStackListArguments checks that the arguments of the frames in
+// the range are listed from one stack trace.
+func TestStackListArguments(t *testing.T) {
+	arg := func(name string, value string) []api.Variable {
+		return []api.Variable{{Name: name, Kind: reflect.Int, Type: "int", Value: value}}
+	}
+	d := &fakeDelve{stacks: map[int64][]api.Stackframe{
+		1: {{Arguments: arg("n", "1")}, {Arguments: arg("n", "2")}, {Arguments: arg("m", "3")}},
+	}}
+	k := newTestKabuta(t, d)
+	k.selectedGoroutineID = 1
+
+	resp := k.testCmd("stack-list-arguments", "1", "1", "2").StackListArguments()
+	if resp.err != nil {
+		t.Fatalf("-stack-list-arguments: %s", resp.err)
+	}
+	want := `stack-args=[frame={level="1",args=[{name="n",value="2"}]},frame={level="2",args=[{name="m",value="3"}]}]`
+	if resp.results != want {
+		t.Errorf("-stack-list-arguments 1 1 2 = %s, want %s", resp.results, want)
+	}
+	if d.fullStacktraces != 1 {
+		t.Errorf("%d stack traces with variables, want 1", d.fullStacktraces)
+	}
+}
+
+// TestGlobalOptionsSelection checks that the selection made with --thread
+// and --frame lasts only for the command, unless the command selects.
+func TestGlobalOptionsSelection(t *testing.T) {
+	stack := []api.Stackframe{{}, {}, {}}
+	d := &fakeDelve{stacks: map[int64][]api.Stackframe{1: stack, 2: stack}}
+	k := newTestKabuta(t, d)
+	k.selectedGoroutineID = 1
+
+	tests := []struct {
+		cmd       string
+		args      []string
+		method    func(*gdbCmd) gdbMiResponse
+		goroutine int64
+		frame     int
+	}{
+		{"stack-info-frame", []string{"--thread", "2", "--frame", "1"}, (*gdbCmd).StackInfoFrame, 1, 0},
+		{"thread-select", []string{"--thread", "2", "2"}, (*gdbCmd).ThreadSelect, 2, 0},
+		{"thread-select", []string{"1"}, (*gdbCmd).ThreadSelect, 1, 0},
+		{"stack-select-frame", []string{"--thread", "2", "--frame", "1", "1"}, (*gdbCmd).StackSelectFrame, 2, 1},
+		{"stack-select-frame", []string{"--thread", "1", "2"}, (*gdbCmd).StackSelectFrame, 1, 2},
+	}
+	for _, test := range tests {
+		c := k.testCmd(test.cmd, test.args...)
+		resp := processWithGlobalOptions(t, c, test.method)
+		if resp.err != nil {
+			t.Fatalf("-%s %s: %s", test.cmd, c.argsStr, resp.err)
+		}
+		if k.selectedGoroutineID != test.goroutine || k.selectedFrame != test.frame {
+			t.Errorf("after -%s %s, selected goroutine %d, frame %d; want goroutine %d, frame %d",
+				test.cmd, strings.Join(test.args, " "), k.selectedGoroutineID, k.selectedFrame, test.goroutine, test.frame)
+		}
+	}
+}
+
+// TestGlobalOptionsThreadWithoutGoroutine checks that Delve is switched
+// back after a command given --thread for an OS thread with no goroutine.
+func TestGlobalOptionsThreadWithoutGoroutine(t *testing.T) {
+	d := &fakeDelve{
+		stacks:          map[int64][]api.Stackframe{-1: {{}}, 1: {{}}},
+		threads:         []*api.Thread{{ID: 1, GoroutineID: 1}, {ID: 5}},
+		currentThreadID: 1,
+	}
+	k := newTestKabuta(t, d)
+	k.threadModel = ThreadModelThreads
+	k.dlvState = &api.DebuggerState{CurrentThread: d.threads[0]}
+	k.resetSelection()
+
+	c := k.testCmd("stack-info-frame", "--thread", "5")
+	resp := processWithGlobalOptions(t, c, (*gdbCmd).StackInfoFrame)
+	if resp.err != nil {
+		t.Fatalf("-stack-info-frame --thread 5: %s", resp.err)
+	}
+	if d.currentThreadID != 1 || k.dlvThreadID() != 1 {
+		t.Errorf("Delve's thread after -stack-info-frame --thread 5 is %d (kabuta thinks %d), want 1", d.currentThreadID, k.dlvThreadID())
+	}
+	if k.selectedThreadID != 1 || k.selectedGoroutineID != 1 {
+		t.Errorf("selected thread %d, goroutine %d, want thread 1, goroutine 1", k.selectedThreadID, k.selectedGoroutineID)
+	}
+
+	c = k.testCmd("thread-select", "5")
+	resp = processWithGlobalOptions(t, c, (*gdbCmd).ThreadSelect)
+	if resp.err != nil {
+		t.Fatalf("-thread-select 5: %s", resp.err)
+	}
+	if d.currentThreadID != 5 || k.selectedThreadID != 5 {
+		t.Errorf("after -thread-select 5, Delve's thread is %d, selected thread %d, want 5", d.currentThreadID, k.selectedThreadID)
+	}
+}
+
+// TestSetKabutaWhileRunning checks that kabuta settings, which execute()
+// reads while the target runs, cannot be changed then.
+func TestSetKabutaWhileRunning(t *testing.T) {
+	k := newTestKabuta(t, &fakeDelve{})
+	k.showSystemGoroutines = true
+	k.running = true
+	for _, args := range [][]string{
+		{"kabuta", "thread-model", ThreadModelThreads},
+		{"kabuta", "group-goroutines", "on"},
+		{"kabuta", "show-system-goroutines", "off"},
+	} {
+		if resp := k.testCmd("gdb-set", args...).GdbSet(); resp.err == nil {
+			t.Errorf("-gdb-set %s succeeded while the target is running", strings.Join(args, " "))
+		}
+	}
+	if k.threadModel != ThreadModelGoroutines || k.groupGoroutines || !k.showSystemGoroutines {
+		t.Errorf("settings changed while the target is running")
+	}
+}
+
+// TestNewBreakpointLinespec checks how each kind of linespec is parsed,
+// relative to where the target stopped.
+func TestNewBreakpointLinespec(t *testing.T) {
+	d := &fakeDelve{sources: []string{"/src/cli/main.go"}}
+	k := newTestKabuta(t, d)
+	k.dlvState = &api.DebuggerState{CurrentThread: testStoppedThread(testLocation("main.main", 20))}
+	tests := []struct {
+		location       string
+		breakpointType int
+		fileName       string
+		lineNo         int
+		function       string
+		label          string
+		addr           uint64
+	}{
+		{"30", breakpointTypeLinenum, "/src/cli/main.go", 30, "", "", 0},
+		{"+2", breakpointTypeOffset, "/src/cli/main.go", 22, "", "", 0},
+		{"-3", breakpointTypeOffset, "/src/cli/main.go", 17, "", "", 0},
+		{"main.go:12", breakpointTypeFilenameLinenum, "/src/cli/main.go", 12, "", "", 0},
+		{"main.worker", breakpointTypeFunction, "", 0, "main.worker", "", 0},
+		{"main.go:worker", breakpointTypeFilenameFunction, "/src/cli/main.go", 0, "worker", "", 0},
+		{"main.main:retry", breakpointTypeFunctionLabel, "", 0, "main.main", "retry", 0},
+		{"*0x401000", breakpointTypeAddress, "", 0, "", "", 0x401000},
+	}
+	for _, test := range tests {
+		bp, err := k.newBreakpoint(test.location, false)
+		if err != nil {
+			t.Errorf("newBreakpoint(%s): %s", test.location, err)
+			continue
+		}
+		if bp.breakpointType != test.breakpointType || bp.fileName != test.fileName || bp.lineNo != test.lineNo ||
+			bp.function != test.function || bp.label != test.label || bp.dlvBreakpoint.Addr != test.addr {
+			t.Errorf("newBreakpoint(%s) = %s, want type %d at %s:%d, function %q, label %q, address %#x", test.location,
+				bp, test.breakpointType, test.fileName, test.lineNo, test.function, test.label, test.addr)
+		}
+	}
+	for _, location := range []string{"*main", "-x", "a:b:c", "other.go:12"} {
+		if bp, err := k.newBreakpoint(location, false); err == nil {
+			t.Errorf("newBreakpoint(%s) = %s, want an error", location, bp)
+		}
+	}
+}
+
+// TestFunctionLabelBreakpoint checks that a label is found in its function,
+// and that the search for code after it stops at the end of the function.
+func TestFunctionLabelBreakpoint(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "main.go")
+	source := "package main\n\nfunc main() {\nretry:\n\twork()\n\tgoto retry\n}\n\nfunc other() {\ndone:\n}\nfunc third() {\n\twork()\n}\n"
+	if err := ioutil.WriteFile(fileName, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDelve{
+		locations: map[string][]api.Location{
+			"main.main":  {{File: fileName, Line: 3}},
+			"main.other": {{File: fileName, Line: 9}},
+		},
+		codeLines: map[int]bool{5: true, 6: true, 14: true},
+	}
+	k := newTestKabuta(t, d)
+
+	bp, err := k.newBreakpoint("main.main:retry", false)
+	if err != nil {
+		t.Fatalf("newBreakpoint(): %s", err)
+	}
+	if err := k.resolveBreakpoint(bp); err != nil {
+		t.Fatalf("resolveBreakpoint(): %s", err)
+	}
+	if bp.dlvBreakpoint.File != fileName || bp.dlvBreakpoint.Line != 5 {
+		t.Errorf("breakpoint at %s:%d, want %s:5", bp.dlvBreakpoint.File, bp.dlvBreakpoint.Line, fileName)
+	}
+
+	d.locationExprs = nil
+	bp, err = k.newBreakpoint("main.other:done", false)
+	if err != nil {
+		t.Fatalf("newBreakpoint(): %s", err)
+	}
+	if err := k.resolveBreakpoint(bp); err == nil {
+		t.Errorf("breakpoint at %s:%d, want an error as there is no code after the label", bp.dlvBreakpoint.File, bp.dlvBreakpoint.Line)
+	}
+	want := []string{"main.other", fileName + ":10", fileName + ":11"}
+	if !reflect.DeepEqual(d.locationExprs, want) {
+		t.Errorf("locations looked up %q, want %q", d.locationExprs, want)
+	}
+}
+
+// TestPendingBreakpointResolved checks that the file of a pending
+// FILENAME:LINENUM breakpoint is resolved once Delve knows the sources.
+func TestPendingBreakpointResolved(t *testing.T) {
+	d := &fakeDelve{}
+	k := newTestKabuta(t, d)
+	bp, err := k.newBreakpoint("cli/main.go:12", true)
+	if err != nil {
+		t.Fatalf("newBreakpoint(): %s", err)
+	}
+
+	d.sources = []string{"/src/cli/main.go", "/src/other/main.go"}
+	err = k.createDlvBreakpoint(bp)
+	if err != nil {
+		t.Fatalf("createDlvBreakpoint(): %s", err)
+	}
+	dlvBp := d.breakpoints[bp.dlvBreakpoint.ID]
+	if dlvBp == nil || dlvBp.File != "/src/cli/main.go" || dlvBp.Line != 12 {
+		t.Errorf("Delve breakpoint is %+v, want one at /src/cli/main.go:12", dlvBp)
+	}
+}
+
+// TestDeleteCatchpoint checks that deleting a catchpoint gives Delve's
+// panic breakpoint back to the remaining catchpoint, or re-enables it.
+func TestDeleteCatchpoint(t *testing.T) {
+	d := &fakeDelve{breakpoints: map[int]*api.Breakpoint{
+		-1: {ID: -1, Name: dlvUnrecoveredPanic},
+	}}
+	k := newTestKabuta(t, d)
+	for _, cmd := range [][]string{
+		{"catch-panic"},
+		{"catch-panic"},
+		{"break-disable", "2"},
+		{"break-condition", "1", "x > 1"},
+		{"break-delete", "1"},
+	} {
+		c := k.testCmd(cmd[0], cmd[1:]...)
+		var resp gdbMiResponse
+		switch cmd[0] {
+		case "catch-panic":
+			resp = c.CatchPanic()
+		case "break-disable":
+			resp = c.BreakDisable()
+		case "break-condition":
+			resp = c.BreakCondition()
+		case "break-delete":
+			resp = c.BreakDelete()
+		}
+		if resp.err != nil {
+			t.Fatalf("-%s: %s", strings.Join(cmd, " "), resp.err)
+		}
+	}
+	if dlvBp := d.breakpoints[-1]; !dlvBp.Disabled || dlvBp.Cond != "" {
+		t.Errorf("with catchpoint 2 disabled, Delve's breakpoint is %+v, want it disabled and unconditional", *dlvBp)
+	}
+
+	resp := k.testCmd("break-delete", "2").BreakDelete()
+	if resp.err != nil {
+		t.Fatalf("-break-delete 2: %s", resp.err)
+	}
+	if dlvBp := d.breakpoints[-1]; dlvBp.Disabled {
+		t.Errorf("with no catchpoints, Delve's breakpoint is %+v, want it enabled", *dlvBp)
+	}
+}
+
+// TestFatalThrowException checks that the message of a fatal error is
+// read in the frame of runtime.throw(), and left out if there is none.
+func TestFatalThrowException(t *testing.T) {
+	loc := func(function string) api.Location {
+		return api.Location{Function: &api.Function{Name_: function}}
+	}
+	d := &fakeDelve{
+		evalResult: api.Variable{Kind: reflect.String, Value: "concurrent map writes"},
+		stacks: map[int64][]api.Stackframe{
+			1: {{Location: loc("runtime.fatalthrow")}, {Location: loc("runtime.throw")}, {Location: loc("runtime.mapassign")}},
+			2: {{Location: loc("runtime.fatalthrow")}, {Location: loc("main.main")}},
+		},
+	}
+	k := newTestKabuta(t, d)
+	dlvBp := &api.Breakpoint{ID: -2, Name: dlvFatalThrow}
+
+	results := k.catchpointResults(&api.Thread{GoroutineID: 1, Breakpoint: dlvBp})
+	if !strings.Contains(results, `exception="concurrent map writes",`) {
+		t.Errorf("results = %s, want exception=\"concurrent map writes\"", results)
+	}
+	want := api.EvalScope{GoroutineID: 1, Frame: 1}
+	if len(d.evalScopes) != 1 || d.evalScopes[0] != want {
+		t.Errorf("message evaluated in %+v, want %+v", d.evalScopes, want)
+	}
+
+	results = k.catchpointResults(&api.Thread{GoroutineID: 2, Breakpoint: dlvBp})
+	if strings.Contains(results, "exception=") || len(d.evalScopes) != 1 {
+		t.Errorf("results = %s after evaluating in %+v, want no exception", results, d.evalScopes)
+	}
+}
+
+// TestBreakWatch checks the kinds of watchpoints -break-watch creates.
+func TestBreakWatch(t *testing.T) {
+	tests := []struct {
+		args      []string
+		watchType api.WatchType
+		want      string
+	}{
+		{[]string{"x"}, api.WatchWrite, `wpt={number="1",exp="x"}`},
+		{[]string{"-r", "x"}, api.WatchRead, `hw-rwpt={number="2",exp="x"}`},
+		{[]string{"-a", "x"}, api.WatchRead | api.WatchWrite, `hw-awpt={number="3",exp="x"}`},
+	}
+	d := &fakeDelve{evalResult: api.Variable{Kind: reflect.Int, Value: "1"}}
+	k := newTestKabuta(t, d)
+	k.selectedGoroutineID = 1
+	for _, test := range tests {
+		resp := k.testCmd("break-watch", test.args...).BreakWatch()
+		if resp.err != nil {
+			t.Fatalf("-break-watch %s: %s", test.args, resp.err)
+		}
+		if resp.results != test.want {
+			t.Errorf("-break-watch %s = %s, want %s", test.args, resp.results, test.want)
+		}
+		bp := k.breakpoints[k.lastBreakpointNo]
+		if got := d.breakpoints[bp.dlvBreakpoint.ID]; got.WatchExpr != "x" || got.WatchType != test.watchType {
+			t.Errorf("-break-watch %s created %+v, want watch type %d", test.args, got, test.watchType)
+		}
+	}
+}
+
+// TestWatchpointStops checks the *stopped records of a watchpoint that
+// triggers and then goes out of scope, after which it is gone.
+func TestWatchpointStops(t *testing.T) {
+	d := &fakeDelve{
+		evalResult: api.Variable{Kind: reflect.Int, Value: "1"},
+		stacks: map[int64][]api.Stackframe{
+			1: {{Location: testLocation("main.main", 20)}, {Location: testLocation("runtime.main", 250)}},
+		},
+	}
+	k := newTestKabuta(t, d)
+	k.selectedGoroutineID = 1
+	if resp := k.testCmd("break-watch", "x").BreakWatch(); resp.err != nil {
+		t.Fatalf("-break-watch: %s", resp.err)
+	}
+	dlvBp := k.breakpoints[1].dlvBreakpoint
+
+	// x changes in a function main.main calls.
+	d.stacks[1] = append([]api.Stackframe{{Location: testLocation("main.update", 30)}}, d.stacks[1]...)
+	d.evalResult.Value = "2"
+	thread := testStoppedThread(testLocation("main.update", 30))
+	thread.Breakpoint = dlvBp
+	d.stopState = api.DebuggerState{CurrentThread: thread}
+	k.startExecution()
+	k.execute(api.Continue)
+
+	thread.Breakpoint = nil
+	d.stopState = api.DebuggerState{CurrentThread: thread, WatchOutOfScope: []*api.Breakpoint{dlvBp}}
+	k.startExecution()
+	k.execute(api.Continue)
+
+	got := sentRecords(t, k, "*stopped")
+	wants := []string{
+		`*stopped,reason="watchpoint-trigger",wpt={number="1",exp="x"},value={old="1",new="2"},frame={`,
+		`*stopped,reason="watchpoint-scope",wpnum="1",frame={`,
+	}
+	if len(got) != len(wants) {
+		t.Fatalf("records = %q, want %d", got, len(wants))
+	}
+	for i, want := range wants {
+		if !strings.HasPrefix(got[i], want) {
+			t.Errorf("record = %s, want %s...", got[i], want)
+		}
+	}
+	if len(k.breakpoints) != 0 {
+		t.Errorf("breakpoints = %v after the watchpoint went out of scope, want none", k.breakpoints)
+	}
+}
+
+// TestWatchpointTriggerValue checks that the new value of a watched
+// expression is read in the frame the watchpoint was set in, and left
+// out if that frame is gone.
+func TestWatchpointTriggerValue(t *testing.T) {
+	loc := func(function string) api.Location {
+		return api.Location{Function: &api.Function{Name_: function}}
+	}
+	d := &fakeDelve{
+		evalResult: api.Variable{Kind: reflect.Int, Value: "2"},
+		stacks: map[int64][]api.Stackframe{
+			1: {{Location: loc("main.update")}, {Location: loc("main.main")}, {Location: loc("runtime.main")}},
+		},
+	}
+	k := newTestKabuta(t, d)
+	bp := &breakpoint{
+		number:               1,
+		rawLocation:          "x",
+		breakpointType:       breakpointTypeWatchpoint,
+		dlvBreakpoint:        &api.Breakpoint{WatchType: api.WatchWrite},
+		watchValue:           "1",
+		watchScope:           api.EvalScope{GoroutineID: 1},
+		watchFrameFromBottom: 2,
+		watchFunction:        "main.main",
+	}
+
+	results := k.watchpointTriggerResults(bp)
+	want := `reason="watchpoint-trigger",wpt={number="1",exp="x"},value={old="1",new="2"},`
+	if results != want {
+		t.Errorf("results = %s, want %s", results, want)
+	}
+	wantScope := api.EvalScope{GoroutineID: 1, Frame: 1}
+	if len(d.evalScopes) != 1 || d.evalScopes[0] != wantScope {
+		t.Errorf("value read in %+v, want %+v", d.evalScopes, wantScope)
+	}
+
+	bp.watchFunction = "main.other"
+	results = k.watchpointTriggerResults(bp)
+	want = `reason="watchpoint-trigger",wpt={number="1",exp="x"},value={old="2"},`
+	if results != want {
+		t.Errorf("results with the frame gone = %s, want %s", results, want)
+	}
+}
+
+func TestFormatInteger(t *testing.T) {
+	tests := []struct {
+		kind   reflect.Kind
+		value  string
+		format string
+		want   string
+	}{
+		{reflect.Int, "42", formatHexadecimal, "0x2a"},
+		{reflect.Int, "42", formatOctal, "052"},
+		{reflect.Int, "42", formatBinary, "101010"},
+		{reflect.Int, "42", formatDecimal, "42"},
+		{reflect.Int, "42", formatZeroHexadecimal, "0x000000000000002a"},
+		{reflect.Int, "0", formatOctal, "0"},
+		{reflect.Int8, "-1", formatHexadecimal, "0xff"},
+		{reflect.Int8, "-1", formatOctal, "0377"},
+		{reflect.Int8, "-1", formatBinary, "11111111"},
+		{reflect.Int8, "-1", formatDecimal, "-1"},
+		{reflect.Int16, "-2", formatZeroHexadecimal, "0xfffe"},
+		{reflect.Int32, "-255", formatHexadecimal, "0xffffff01"},
+		{reflect.Int64, "-1", formatHexadecimal, "0xffffffffffffffff"},
+		{reflect.Uint8, "255", formatHexadecimal, "0xff"},
+		{reflect.Uint16, "10", formatZeroHexadecimal, "0x000a"},
+		{reflect.Uint64, "18446744073709551615", formatDecimal, "18446744073709551615"},
+		{reflect.Uint64, "18446744073709551615", formatOctal, "01777777777777777777777"},
+	}
+	for _, test := range tests {
+		v := &api.Variable{Kind: test.kind, Value: test.value}
+		got, ok := formatInteger(v, test.format)
+		if !ok || got != test.want {
+			t.Errorf("formatInteger(%s %s, %s) = %q, %v, want %q", test.kind, test.value, test.format, got, ok, test.want)
+		}
+	}
+
+	// Natural format, and values other than integers, are rendered as usual.
+	for _, test := range []struct {
+		v      api.Variable
+		format string
+	}{
+		{api.Variable{Kind: reflect.Int, Value: "42"}, formatNatural},
+		{api.Variable{Kind: reflect.String, Value: "42"}, formatHexadecimal},
+		{api.Variable{Kind: reflect.Float64, Value: "4.2"}, formatHexadecimal},
+		{api.Variable{Kind: reflect.Int, Value: "42", Unreadable: "bad"}, formatHexadecimal},
+	} {
+		if got, ok := formatInteger(&test.v, test.format); ok {
+			t.Errorf("formatInteger(%s %s, %s) = %q, want it rendered as usual", test.v.Kind, test.v.Value, test.format, got)
+		}
+	}
+}
+
+func TestFormatDprintfValue(t *testing.T) {
+	tests := []struct {
+		spec string
+		verb byte
+		v    api.Variable
+		want string
+	}{
+		{"%", 'd', api.Variable{Kind: reflect.Int, Value: "42"}, "42"},
+		{"%", 'i', api.Variable{Kind: reflect.Int, Value: "-42"}, "-42"},
+		{"%", 'u', api.Variable{Kind: reflect.Uint, Value: "42"}, "42"},
+		{"%", 'x', api.Variable{Kind: reflect.Int, Value: "255"}, "ff"},
+		{"%", 'X', api.Variable{Kind: reflect.Int, Value: "255"}, "FF"},
+		{"%", 'o', api.Variable{Kind: reflect.Int, Value: "8"}, "10"},
+		{"%#", 'x', api.Variable{Kind: reflect.Int, Value: "255"}, "0xff"},
+		{"%04", 'd', api.Variable{Kind: reflect.Int, Value: "7"}, "0007"},
+		{"%", 'x', api.Variable{Kind: reflect.Uint64, Value: "18446744073709551615"}, "ffffffffffffffff"},
+		// Negative values are shown as unsigned of the variable's size, as in C.
+		{"%", 'x', api.Variable{Kind: reflect.Int8, Value: "-1"}, "ff"},
+		{"%", 'X', api.Variable{Kind: reflect.Int16, Value: "-2"}, "FFFE"},
+		{"%", 'o', api.Variable{Kind: reflect.Int8, Value: "-1"}, "377"},
+		{"%", 'u', api.Variable{Kind: reflect.Int32, Value: "-1"}, "4294967295"},
+		{"%", 'x', api.Variable{Kind: reflect.Int, Value: "-1"}, "ffffffffffffffff"},
+		{"%", 'd', api.Variable{Kind: reflect.Int8, Value: "-1"}, "-1"},
+		{"%", 'c', api.Variable{Kind: reflect.Int32, Value: "65"}, "A"},
+		{"%.2", 'f', api.Variable{Kind: reflect.Float64, Value: "3.14159"}, "3.14"},
+		{"%", 's', api.Variable{Kind: reflect.String, Value: "hi"}, "hi"},
+		{"%5", 's', api.Variable{Kind: reflect.String, Value: "hi"}, "   hi"},
+		{"%", 'd', api.Variable{Kind: reflect.Int, Unreadable: "bad"}, "<bad>"},
+	}
+	for _, test := range tests {
+		if got := formatDprintfValue(test.spec, test.verb, &test.v); got != test.want {
+			t.Errorf("formatDprintfValue(%q, %c, %s %s) = %q, want %q", test.spec, test.verb, test.v.Kind, test.v.Value, got, test.want)
+		}
+	}
+}
+
+func TestFormatDprintf(t *testing.T) {
+	values := []api.Variable{
+		{Kind: reflect.Int, Value: "-1"},
+		{Kind: reflect.String, Value: "done"},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{`i=%d s=%s\n`, `i=-1 s=done\n`},
+		{"%ld%%", "-1%"},
+		{"%d %s %d", "-1 done <missing>"},
+		{"tail %", "tail %"},
+	}
+	for _, test := range tests {
+		if got := formatDprintf(test.format, values); got != test.want {
+			t.Errorf("formatDprintf(%q) = %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestParenthesize(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"x", "x"},
+		{"a.b[1].c", "a.b[1].c"},
+		{"f(a + b, c)", "f(a + b, c)"},
+		{"(*p).x", "(*p).x"},
+		{"*p", "(*p)"},
+		{"&x", "(&x)"},
+		{"a + b", "(a + b)"},
+		{"(a)+(b)", "((a)+(b))"},
+		{`m["a b"]`, `m["a b"]`},
+		{`m["a)*b"]`, `m["a)*b"]`},
+		{`m["a\"*"]`, `m["a\"*"]`},
+		{`m['*']`, `m['*']`},
+	}
+	for _, test := range tests {
+		if got := parenthesize(test.expr); got != test.want {
+			t.Errorf("parenthesize(%q) = %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestChildPath(t *testing.T) {
+	fields := []api.Variable{{Name: "x", Kind: reflect.Int}, {Name: "y", Kind: reflect.Int}}
+	structVar := api.Variable{Kind: reflect.Struct, Children: fields}
+	intVar := api.Variable{Kind: reflect.Int, Addr: 0xc000010000, Value: "1"}
+	ptrToStruct := api.Variable{Kind: reflect.Ptr, Children: []api.Variable{{Kind: reflect.Struct, Addr: 0xc000010000, Children: fields}}}
+	ptrToInt := api.Variable{Kind: reflect.Ptr, Children: []api.Variable{intVar}}
+	ptrToPtr := api.Variable{Kind: reflect.Ptr, Children: []api.Variable{{Kind: reflect.Ptr, Addr: 0xc000010008, Children: []api.Variable{intVar}}}}
+	tests := []struct {
+		v     api.Variable
+		path  string
+		i     int
+		index int
+		want  string
+	}{
+		{structVar, "s", 1, 1, "s.y"},
+		{structVar, "a[2]", 0, 0, "a[2].x"},
+		{structVar, "*p", 0, 0, "(*p).x"},
+		{api.Variable{Kind: reflect.Slice}, "s", 0, 5, "s[5]"},
+		{api.Variable{Kind: reflect.Array}, "*p", 0, 2, "(*p)[2]"},
+		{api.Variable{Kind: reflect.Slice}, "a.b", 1, 1, "a.b[1]"},
+		{api.Variable{Kind: reflect.Map, Children: []api.Variable{
+			{Kind: reflect.String, Value: "a", Len: 1}, intVar,
+			{Kind: reflect.String, Value: "b c", Len: 3}, intVar,
+		}}, "m", 1, 1, `m["b c"]`},
+		{api.Variable{Kind: reflect.Map, Children: []api.Variable{{Kind: reflect.Int, Value: "3"}, intVar}}, "(*pm)", 0, 0, "(*pm)[3]"},
+		{ptrToStruct, "p", 1, 1, "(*p).y"},
+		{ptrToStruct, "s.next", 0, 0, "(*s.next).x"},
+		{ptrToInt, "p", 0, 0, "*p"},
+		{ptrToInt, "a[1]", 0, 0, "*a[1]"},
+		{ptrToPtr, "pp", 0, 0, "*pp"},
+		{ptrToInt, "*pp", 0, 0, "*(*pp)"},
+		{ptrToStruct, "*pp", 0, 0, "(*(*pp)).x"},
+		{api.Variable{Kind: reflect.Interface, Children: []api.Variable{{Kind: reflect.Int, Type: "int"}}}, "i", 0, 0, "i.(int)"},
+	}
+	for _, test := range tests {
+		if got := childPath(&test.v, test.path, test.i, test.index); got != test.want {
+			t.Errorf("childPath(%s, %q, %d, %d) = %q, want %q", test.v.Kind, test.path, test.i, test.index, got, test.want)
+		}
+	}
+}
+
+func TestSplitAssignment(t *testing.T) {
+	tests := []struct {
+		s        string
+		variable string
+		value    string
+		ok       bool
+	}{
+		{"x = 1", "x", "1", true},
+		{"x=1", "x", "1", true},
+		{"s.f[2] = -1", "s.f[2]", "-1", true},
+		{`m["a=b"] = 1`, `m["a=b"]`, "1", true},
+		{`m['='] = 1`, `m['=']`, "1", true},
+		{`m[f(a==b)] = "c = d"`, "m[f(a==b)]", `"c = d"`, true},
+		{"x = y == z", "x", "y == z", true},
+		{"b = x != 1", "b", "x != 1", true},
+		{"x == 1", "", "", false},
+		{"x >= 1", "", "", false},
+		{"x <= 1", "", "", false},
+		{"x != 1", "", "", false},
+		{`m["a=b"] == 1`, "", "", false},
+		{"f(a=b)", "", "", false},
+		{"x := 1", "", "", false},
+		{"= 1", "", "", false},
+		{"x =", "", "", false},
+		{"pending on", "", "", false},
+	}
+	for _, test := range tests {
+		variable, value, ok := splitAssignment(test.s)
+		if variable != test.variable || value != test.value || ok != test.ok {
+			t.Errorf("splitAssignment(%q) = %q, %q, %v, want %q, %q, %v", test.s, variable, value, ok, test.variable, test.value, test.ok)
+		}
+	}
+}
+
+// TestStoppedByTarget checks which stops are reported as they are
+// rather than as the interrupt that was in progress.
+func TestStoppedByTarget(t *testing.T) {
+	tests := []struct {
+		name  string
+		state api.DebuggerState
+		want  bool
+	}{
+		{"halted", api.DebuggerState{CurrentThread: &api.Thread{ID: 1}}, false},
+		{"no thread", api.DebuggerState{}, false},
+		{"tracepoint", api.DebuggerState{CurrentThread: &api.Thread{Breakpoint: &api.Breakpoint{ID: 1, Tracepoint: true}}}, false},
+		{"breakpoint", api.DebuggerState{CurrentThread: &api.Thread{Breakpoint: &api.Breakpoint{ID: 1}}}, true},
+		{"catchpoint", api.DebuggerState{CurrentThread: &api.Thread{Breakpoint: &api.Breakpoint{ID: -1, Name: dlvUnrecoveredPanic}}}, true},
+		{"watchpoint scope", api.DebuggerState{WatchOutOfScope: []*api.Breakpoint{{ID: 2}}}, true},
+		{"exited", api.DebuggerState{Exited: true, ExitStatus: 2}, true},
+	}
+	for _, test := range tests {
+		if got := stoppedByTarget(&test.state); got != test.want {
+			t.Errorf("stoppedByTarget(%s) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestFloatingVarobjChildren checks that children of a floating varobj
+// are loaded in the selected frame rather than where it was created.
+func TestFloatingVarobjChildren(t *testing.T) {
+	elems := []api.Variable{{Kind: reflect.Int, Value: "1"}, {Kind: reflect.Int, Value: "2"}}
+	d := &fakeDelve{evalResult: api.Variable{Kind: reflect.Slice, Len: 2, Children: elems}}
+	k := newTestKabuta(t, d)
+	vo := &varobj{
+		name:            "var1",
+		exp:             "s",
+		path:            "s",
+		floating:        true,
+		scope:           api.EvalScope{GoroutineID: 1, Frame: 0},
+		frameFromBottom: -1,
+		variable:        &api.Variable{Kind: reflect.Slice, Len: 2},
+		inScope:         true,
+	}
+	k.varobjs[vo.name] = vo
+	k.selectedGoroutineID, k.selectedFrame = 2, 1
+
+	children, err := k.varobjChildren(vo, 0, 2)
+	if err != nil {
+		t.Fatalf("varobjChildren(): %s", err)
+	}
+	if len(children) != 2 || children[1].value != "2" {
+		t.Errorf("children = %v, want values 1 and 2", children)
+	}
+	want := api.EvalScope{GoroutineID: 2, Frame: 1}
+	if len(d.evalScopes) != 1 || d.evalScopes[0] != want {
+		t.Errorf("children evaluated in %+v, want %+v", d.evalScopes, want)
+	}
+}
+
+// TestVarUpdate checks that -var-update reports changed values, follows
+// the varobj's frame as the stack grows and reports it out of scope
+// once the frame is gone.
+func TestVarUpdate(t *testing.T) {
+	d := testStack()
+	d.evalResult = api.Variable{Kind: reflect.Int, Type: "int", Value: "1"}
+	k := newTestKabuta(t, d)
+	k.selectedGoroutineID, k.selectedFrame = 1, 1
+	update := func() string {
+		t.Helper()
+		resp := k.testCmd("var-update", "--all-values", "*").VarUpdate()
+		if resp.err != nil {
+			t.Fatalf("-var-update: %s", resp.err)
+		}
+		return resp.results
+	}
+	if resp := k.testCmd("var-create", "-", "*", "x").VarCreate(); resp.err != nil {
+		t.Fatalf("-var-create: %s", resp.err)
+	}
+	if got := update(); got != "changelist=[]" {
+		t.Errorf("unchanged: %s, want changelist=[]", got)
+	}
+
+	d.evalResult.Value = "2"
+	d.stacks[1] = append([]api.Stackframe{{Location: testLocation("main.leaf", 50)}}, d.stacks[1]...)
+	want := `changelist=[{name="var1",value="2",in_scope="true",type_changed="false",has_more="0"}]`
+	if got := update(); got != want {
+		t.Errorf("changed: %s, want %s", got, want)
+	}
+	if scope := d.evalScopes[len(d.evalScopes)-1]; scope != (api.EvalScope{GoroutineID: 1, Frame: 2}) {
+		t.Errorf("evaluated in %+v with a frame called since, want frame 2", scope)
+	}
+
+	d.stacks[1] = d.stacks[1][3:]
+	want = `changelist=[{name="var1",in_scope="false",type_changed="false",has_more="0"}]`
+	if got := update(); got != want {
+		t.Errorf("frame gone: %s, want %s", got, want)
+	}
+	if got := update(); got != "changelist=[]" {
+		t.Errorf("still out of scope: %s, want changelist=[]", got)
+	}
+}
+
+// TestVarUpdateNumChildren checks that a change in the number of
+// children is reported along with the value.
+func TestVarUpdateNumChildren(t *testing.T) {
+	k := newTestKabuta(t, &fakeDelve{})
+	slice := func(n int) *api.Variable {
+		return &api.Variable{Kind: reflect.Slice, Type: "[]int", Len: int64(n), Cap: int64(n)}
+	}
+	vo := &varobj{name: "var1", exp: "s", path: "s", variable: slice(2), inScope: true}
+	vo.value = vo.render(vo.variable)
+	k.varobjs[vo.name] = vo
+
+	changes := k.refreshVarobj(vo, slice(3), printNoValues)
+	want := []string{`{name="var1",in_scope="true",type_changed="false",new_num_children="3",has_more="0"}`}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %q, want %q", changes, want)
+	}
+}
+
+// TestVarListChildrenRange checks that elements beyond those Delve
+// loaded are listed by range, loading only that range.
+func TestVarListChildrenRange(t *testing.T) {
+	window := []api.Variable{{Kind: reflect.Int, Type: "int", Value: "3"}, {Kind: reflect.Int, Type: "int", Value: "4"}}
+	d := &fakeDelve{evalResult: api.Variable{Kind: reflect.Slice, Type: "[]int", Len: 2, Children: window}}
+	k := newTestKabuta(t, d)
+	loaded := []api.Variable{{Kind: reflect.Int, Type: "int", Value: "1"}, {Kind: reflect.Int, Type: "int", Value: "2"}}
+	vo := &varobj{name: "var1", exp: "s", path: "s", frameFromBottom: -1, variable: &api.Variable{Kind: reflect.Slice, Type: "[]int", Len: 5, Children: loaded}, inScope: true}
+	k.varobjs[vo.name] = vo
+
+	resp := k.testCmd("var-list-children", "--all-values", "var1", "2", "4").VarListChildren()
+	want := `numchild="2",children=[child={name="var1.2",exp="2",numchild="0",value="3",type="int"},child={name="var1.3",exp="3",numchild="0",value="4",type="int"}],has_more="1"`
+	if resp.err != nil || resp.results != want {
+		t.Errorf("-var-list-children var1 2 4 = %+v, want %s", resp, want)
+	}
+	if !reflect.DeepEqual(d.evalExprs, []string{"s[2:4]"}) {
+		t.Errorf("evaluated %q, want s[2:4]", d.evalExprs)
+	}
+	if child := k.varobjs["var1.3"]; child == nil || child.path != "s[3]" {
+		t.Errorf("var1.3 = %+v, want path s[3]", child)
+	}
+
+	resp = k.testCmd("var-list-children", "var1", "4", "10").VarListChildren()
+	want = `numchild="1",children=[child={name="var1.4",exp="4",numchild="0",type="int"}],has_more="0"`
+	if resp.err != nil || resp.results != want {
+		t.Errorf("-var-list-children var1 4 10 = %+v, want %s", resp, want)
+	}
+}
+
+// TestVarSetUpdateRange checks that -var-update only re-evaluates
+// children in the range set, and that the varobj has more beyond it.
+func TestVarSetUpdateRange(t *testing.T) {
+	elems := func(values ...string) []api.Variable {
+		vars := make([]api.Variable, len(values))
+		for i, value := range values {
+			vars[i] = api.Variable{Kind: reflect.Int, Type: "int", Value: value}
+		}
+		return vars
+	}
+	d := &fakeDelve{}
+	k := newTestKabuta(t, d)
+	vo := &varobj{name: "var1", exp: "s", path: "s", frameFromBottom: -1, variable: &api.Variable{Kind: reflect.Slice, Type: "[]int", Len: 2000, Children: elems("0", "1")}, inScope: true}
+	vo.value = vo.render(vo.variable)
+	k.varobjs[vo.name] = vo
+	d.evalResult = api.Variable{Kind: reflect.Slice, Type: "[]int", Len: 2, Children: elems("10", "11")}
+	if _, err := k.varobjChildren(vo, 1000, 1002); err != nil {
+		t.Fatal(err)
+	}
+	if resp := k.testCmd("var-set-update-range", "var1", "1000", "1002").VarSetUpdateRange(); resp.err != nil {
+		t.Fatal(resp.err)
+	}
+
+	d.evalExprs = nil
+	d.evalResult = api.Variable{Kind: reflect.Slice, Type: "[]int", Len: 2, Children: elems("10", "12")}
+	changes := k.refreshVarobj(vo, &api.Variable{Kind: reflect.Slice, Type: "[]int", Len: 2000, Children: elems("0", "1")}, printAllValues)
+	want := []string{`{name="var1.1001",value="12",in_scope="true",type_changed="false",has_more="0"}`}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %q, want %q", changes, want)
+	}
+	if !reflect.DeepEqual(d.evalExprs, []string{"s[1000:1002]"}) {
+		t.Errorf("evaluated %q, want only the range", d.evalExprs)
+	}
+	if !vo.hasMore() {
+		t.Error("hasMore() = false with elements beyond the range")
+	}
+}
+
+// TestVarobjDynamicTypeChanged checks that a change of the dynamic type
+// of an interface varobj is reported with that type.
+func TestVarobjDynamicTypeChanged(t *testing.T) {
+	iface := func(typ string, value string) *api.Variable {
+		return &api.Variable{Kind: reflect.Interface, Type: "error", Children: []api.Variable{{Kind: reflect.String, Type: typ, Value: value}}}
+	}
+	k := newTestKabuta(t, &fakeDelve{})
+	vo := &varobj{name: "var1", exp: "err", path: "err", variable: iface("*errors.errorString", "a"), inScope: true}
+	vo.value = vo.render(vo.variable)
+	k.varobjs[vo.name] = vo
+
+	changes := k.refreshVarobj(vo, iface("*fs.PathError", "a"), printNoValues)
+	want := []string{`{name="var1",in_scope="true",type_changed="true",new_type="*fs.PathError",new_num_children="1",has_more="0"}`}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %q, want %q", changes, want)
+	}
+	if changes := k.refreshVarobj(vo, iface("*fs.PathError", "a"), printNoValues); len(changes) != 0 {
+		t.Errorf("changes = %q with the same dynamic type, want none", changes)
+	}
+}
+
+// TestFloatingVarobjAssign checks that a floating varobj is assigned
+// and read back in the selected frame rather than the one it was
+// created in.
+func TestFloatingVarobjAssign(t *testing.T) {
+	d := &fakeDelve{evalResult: api.Variable{Kind: reflect.Int, Value: "42"}}
+	k := newTestKabuta(t, d)
+	vo := &varobj{
+		name:            "var1",
+		exp:             "x",
+		path:            "x",
+		floating:        true,
+		scope:           api.EvalScope{GoroutineID: 1, Frame: 0},
+		frameFromBottom: -1,
+		variable:        &api.Variable{Kind: reflect.Int, Value: "1", Addr: 0xc000010000},
+		inScope:         true,
+	}
+	k.varobjs[vo.name] = vo
+	k.selectedGoroutineID, k.selectedFrame = 2, 1
+
+	resp := k.testCmd("var-assign", "var1", "42").VarAssign()
+	if resp.err != nil {
+		t.Fatalf("-var-assign: %s", resp.err)
+	}
+	if resp.results != `value="42"` {
+		t.Errorf("results = %s, want value=\"42\"", resp.results)
+	}
+	want := api.EvalScope{GoroutineID: 2, Frame: 1}
+	if len(d.setScopes) != 1 || d.setScopes[0] != want {
+		t.Errorf("assigned in %+v, want %+v", d.setScopes, want)
+	}
+	if len(d.evalScopes) != 1 || d.evalScopes[0] != want {
+		t.Errorf("read back in %+v, want %+v", d.evalScopes, want)
+	}
+}
+
+// TestThreadCore checks that the core a thread last ran on is read
+// from /proc on Linux.
+func TestThreadCore(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cores are only known on Linux")
+	}
+	core, ok := threadCore(os.Getpid(), os.Getpid())
+	// The main thread's ID is the process ID.
+	if !ok || core < 0 {
+		t.Errorf("threadCore() = %d, %t, want a core", core, ok)
+	}
+	if _, ok := threadCore(os.Getpid(), 0); ok {
+		t.Error("threadCore() found a core for no thread")
+	}
+}
+
+// TestInfoThreads checks that the info threads CLI command reaches
+// InfoThreads() through the info command.
+func TestInfoThreads(t *testing.T) {
+	loc := api.Location{File: "/src/cli/main.go", Line: 12, Function: &api.Function{Name_: "main.main"}}
+	d := &fakeDelve{goroutines: []*api.Goroutine{{ID: 1, CurrentLoc: loc, StartLoc: loc}}}
+	k := newTestKabuta(t, d)
+	k.miCmdRegexp = regexp.MustCompile(RegexpMiCmd)
+	k.cliCmdRegexp = regexp.MustCompile(RegexpCliCmd)
+	k.selectedGoroutineID = 1
+
+	newFrontendRequest(k, "info threads").process()
+	want := []string{
+		`~"  Id   Target Id                         Frame \n"`,
+		`~"* 1    Goroutine 1 \"main.main\"           main.main () at /src/cli/main.go:12\n"`,
+	}
+	if got := sentRecords(t, k, "~"); !reflect.DeepEqual(got, want) {
+		t.Errorf("info threads printed %q, want %q", got, want)
+	}
+	if got := sentRecords(t, k, "^"); !reflect.DeepEqual(got, []string{"^done"}) {
+		t.Errorf("info threads answered %q, want ^done", got)
+	}
+}
+
+// testGoroutines returns a fake Delve with goroutine 1 running main.main
+// and goroutines 2 and 3 started by main.worker.
+func testGoroutines() *fakeDelve {
+	main := testLocation("main.main", 12)
+	worker := testLocation("main.worker", 30)
+	return &fakeDelve{
+		pid: 4242,
+		goroutines: []*api.Goroutine{
+			{ID: 1, CurrentLoc: main, StartLoc: main},
+			{ID: 2, CurrentLoc: worker, StartLoc: worker},
+			{ID: 3, CurrentLoc: worker, StartLoc: worker},
+		},
+	}
+}
+
+// miTestGoroutine formats the goroutine of testGoroutines() as a thread tuple.
+func miTestGoroutine(id int, function string, line int) string {
+	return f(`{id="%d",target-id="Goroutine %d",name="%s",state="stopped",frame={level="0",%s}}`, id, id, function, miFrame(testLocation(function, line)))
+}
+
+// TestListThreadGroups checks that the process is listed as thread group
+// i1, with the goroutines as its threads.
+func TestListThreadGroups(t *testing.T) {
+	k := newTestKabuta(t, testGoroutines())
+	threads := "threads=[" + miTestGoroutine(1, "main.main", 12) + "," + miTestGoroutine(2, "main.worker", 30) + "," + miTestGoroutine(3, "main.worker", 30) + "]"
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, `groups=[{id="i1",type="process",pid="4242"}]`},
+		{[]string{"--recurse", "1"}, `groups=[{id="i1",type="process",pid="4242",` + threads + `}]`},
+		{[]string{"i1"}, threads},
+		{[]string{"--available"}, "groups=[]"},
+	}
+	for _, test := range tests {
+		resp := k.testCmd("list-thread-groups", test.args...).ListThreadGroups()
+		if resp.err != nil {
+			t.Errorf("-list-thread-groups %s: %s", test.args, resp.err)
+		} else if resp.results != test.want {
+			t.Errorf("-list-thread-groups %s = %s, want %s", test.args, resp.results, test.want)
+		}
+	}
+	if resp := k.testCmd("list-thread-groups", "g1").ListThreadGroups(); resp.err == nil {
+		t.Errorf("-list-thread-groups g1 = %s without grouping, want an error", resp.results)
+	}
+}
+
+// TestListThreadGroupsGrouped checks that goroutines are listed in groups
+// by start function, whose IDs stay the same as goroutines come and go.
+func TestListThreadGroupsGrouped(t *testing.T) {
+	d := testGoroutines()
+	k := newTestKabuta(t, d)
+	k.groupGoroutines = true
+	k.goroutineGroupIDs = make(map[string]string)
+	workers := miTestGoroutine(2, "main.worker", 30) + "," + miTestGoroutine(3, "main.worker", 30)
+	want := `groups=[{id="i1",type="process",pid="4242",groups=[` +
+		`{id="g1",type="goroutines",name="main.main",num_children="1",threads=[` + miTestGoroutine(1, "main.main", 12) + `]},` +
+		`{id="g2",type="goroutines",name="main.worker",num_children="2",threads=[` + workers + `]}]}]`
+	resp := k.testCmd("list-thread-groups", "--recurse", "1").ListThreadGroups()
+	if resp.err != nil || resp.results != want {
+		t.Errorf("-list-thread-groups --recurse 1 = %+v, want %s", resp, want)
+	}
+
+	// Once main.main's goroutine is gone, main.worker's group is still g2.
+	d.goroutines = d.goroutines[1:]
+	want = `groups=[{id="g2",type="goroutines",name="main.worker",num_children="2"}]`
+	if resp := k.testCmd("list-thread-groups", "i1").ListThreadGroups(); resp.err != nil || resp.results != want {
+		t.Errorf("-list-thread-groups i1 = %+v, want %s", resp, want)
+	}
+	want = "threads=[" + workers + "]"
+	if resp := k.testCmd("list-thread-groups", "g2").ListThreadGroups(); resp.err != nil || resp.results != want {
+		t.Errorf("-list-thread-groups g2 = %+v, want %s", resp, want)
+	}
+	if resp := k.testCmd("list-thread-groups", "g1").ListThreadGroups(); resp.err == nil {
+		t.Errorf("-list-thread-groups g1 = %s with no goroutines left in it, want an error", resp.results)
+	}
+}
+
+// TestThreadInfoByID checks that -thread-info describes the goroutine
+// asked for from its stack rather than by listing all goroutines.
+func TestThreadInfoByID(t *testing.T) {
+	loc := func(function string, line int) api.Location {
+		return api.Location{PC: 0x401000, File: "/src/cli/main.go", Line: line, Function: &api.Function{Name_: function}}
+	}
+	d := &fakeDelve{
+		goroutines: []*api.Goroutine{{ID: 1}, {ID: 2}},
+		stacks: map[int64][]api.Stackframe{
+			2: {{Location: loc("sync.(*Mutex).Lock", 80)}, {Location: loc("main.worker", 30)}, {Location: loc("runtime.goexit", 1)}},
+		},
+	}
+	k := newTestKabuta(t, d)
+
+	resp := k.testCmd("thread-info", "2").ThreadInfo()
+	if resp.err != nil {
+		t.Fatalf("-thread-info 2: %s", resp.err)
+	}
+	if d.listGoroutinesCalls != 0 {
+		t.Errorf("goroutines listed %d times, want none", d.listGoroutinesCalls)
+	}
+	want := `threads=[{id="2",target-id="Goroutine 2",name="main.worker",state="stopped",frame={level="0",`
+	if !strings.HasPrefix(resp.results, want) || !strings.Contains(resp.results, `func="sync.(*Mutex).Lock"`) {
+		t.Errorf("-thread-info 2 = %s, want %s... in sync.(*Mutex).Lock", resp.results, want)
+	}
+	if resp := k.testCmd("thread-info", "3").ThreadInfo(); resp.err == nil {
+		t.Errorf("-thread-info 3 = %s, want an error", resp.results)
+	}
+}
+
+// TestNotifyThreadChanges checks that the frontend is notified of
+// goroutines created and exited once it has listed threads.
+func TestNotifyThreadChanges(t *testing.T) {
+	d := &fakeDelve{goroutines: []*api.Goroutine{{ID: 1}, {ID: 2}}}
+	k := newTestKabuta(t, d)
+
+	k.notifyThreadChanges(false)
+	if d.listGoroutinesCalls != 0 {
+		t.Errorf("goroutines listed %d times before the frontend listed threads, want none", d.listGoroutinesCalls)
+	}
+
+	if resp := k.testCmd("thread-list-ids").ThreadListIds(); resp.err != nil {
+		t.Fatalf("-thread-list-ids: %s", resp.err)
+	}
+	d.goroutines = []*api.Goroutine{{ID: 1}, {ID: 3}}
+	k.notifyThreadChanges(false)
+	want := []string{
+		`=thread-created,id="3",group-id="i1"`,
+		`=thread-exited,id="2",group-id="i1"`,
+	}
+	if got := sentRecords(t, k, "=thread-"); !reflect.DeepEqual(got, want) {
+		t.Errorf("notifications = %q, want %q", got, want)
+	}
+
+	k.notifyThreadChanges(true)
+	want = append(want, `=thread-exited,id="1",group-id="i1"`, `=thread-exited,id="3",group-id="i1"`)
+	if got := sentRecords(t, k, "=thread-"); !reflect.DeepEqual(got, want) {
+		t.Errorf("notifications after exit = %q, want %q", got, want)
+	}
+}
+
+// TestDeleteTemporaryBreakpointWhileListing checks that the temporary
+// breakpoint that stopped the target is deleted before its commands can
+// list breakpoints, which -race would otherwise report.
+func TestDeleteTemporaryBreakpointWhileListing(t *testing.T) {
+	d := &fakeDelve{breakpoints: make(map[int]*api.Breakpoint), resume: make(chan bool)}
+	k := newTestKabuta(t, d)
+	k.internalChannel = make(chan *frontendRequest, 64)
+	if resp := k.testCmd("break-insert", "-t", "*0x401000").BreakInsert(); resp.err != nil {
+		t.Fatalf("-break-insert: %s", resp.err)
+	}
+	bp := k.breakpoints[k.lastBreakpointNo]
+	number := f("%d", bp.number)
+	if resp := k.testCmd("break-commands", number, "-break-list").BreakCommands(); resp.err != nil {
+		t.Fatalf("-break-commands: %s", resp.err)
+	}
+	d.stopState = api.DebuggerState{CurrentThread: &api.Thread{ID: 1, GoroutineID: 1, Breakpoint: bp.dlvBreakpoint}}
+
+	k.startExecution()
+	done := make(chan bool)
+	go func() {
+		k.execute(api.Continue)
+		close(done)
+	}()
+	d.resume <- true
+	// The breakpoint's commands are queued once the target is marked
+	// as stopped, as process() would then run them.
+	req := <-k.internalChannel
+	if req.rawCmd != "-break-list" {
+		t.Fatalf("queued %q, want -break-list", req.rawCmd)
+	}
+	if k.isRunning() {
+		t.Fatal("target running when breakpoint commands are run")
+	}
+	resp := k.testCmd("break-list").BreakList()
+	if resp.err != nil {
+		t.Fatalf("-break-list: %s", resp.err)
+	}
+	if strings.Contains(resp.results, "number=\""+number+"\"") {
+		t.Errorf("-break-list = %s, want temporary breakpoint %s deleted", resp.results, number)
+	}
+	<-done
+	want := []string{f("=breakpoint-deleted,id=\"%s\"", number)}
+	if got := sentRecords(t, k, "=breakpoint-deleted"); !reflect.DeepEqual(got, want) {
+		t.Errorf("notifications = %q, want %q", got, want)
 	}
-	MakeGdbResult
 }
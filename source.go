@@ -0,0 +1,105 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/rpc2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// resolveSourceFile finds the source file the frontend refers to by fileName,
+// which may be absolute, relative to the directory set by environment-cd,
+// relative to a package directory (e.g., cli/main.go), or just a base name.
+// The places looked at, in order, are:
+//  1. fileName itself, if absolute;
+//  2. the directory set by environment-cd;
+//  3. directories of all packages the binary being debugged is built from;
+//  4. sources Delve knows of (if Delve is running).
+//
+// If fileName matches files in more than one package, an error is returned.
+func (k *kabuta) resolveSourceFile(fileName string) (string, error) {
+	if filepath.IsAbs(fileName) {
+		if fileExists(fileName) {
+			return fileName, nil
+		}
+	} else if k.cwd != "" {
+		path := filepath.Join(k.cwd, fileName)
+		if fileExists(path) {
+			return path, nil
+		}
+	}
+
+	var matches []string
+	for _, dir := range k.packageDirs() {
+		path := filepath.Join(dir, filepath.Base(fileName))
+		if sourceMatches(path, fileName) && fileExists(path) {
+			matches = append(matches, path)
+		}
+	}
+	if len(matches) == 0 && k.dlvRpcClient != nil {
+		out := rpc2.ListSourcesOut{}
+		err := k.dlvRpcClient.Call("RPCServer.ListSources", rpc2.ListSourcesIn{}, &out)
+		if err != nil {
+			k.log("Error listing sources: %s", err)
+		}
+		for _, path := range out.Sources {
+			if sourceMatches(path, fileName) {
+				matches = append(matches, path)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", NewError("No source file named %s.", fileName)
+	case 1:
+		k.log("Resolved source file %s to %s", fileName, matches[0])
+		return matches[0], nil
+	default:
+		sort.Strings(matches)
+		return "", NewError("Source file %s is ambiguous, it matches %s; use a longer path.", fileName, strings.Join(matches, ", "))
+	}
+}
+
+// sourceMatches returns true if fileName (possibly relative) refers to path.
+func sourceMatches(path string, fileName string) bool {
+	fileName = filepath.Clean(fileName)
+	return path == fileName || strings.HasSuffix(path, string(filepath.Separator)+fileName)
+}
+
+// fileExists returns true if the path exists and is not a directory.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// packageDirs returns directories of the packages the binary being
+// debugged is built from, as reported by go list. They are determined
+// once per binary, as go list may take a while.
+func (k *kabuta) packageDirs() []string {
+	if k.debugBinaryPackageDir == "" {
+		return nil
+	}
+	if k.debugBinaryPackageDirs != nil {
+		return k.debugBinaryPackageDirs
+	}
+	goList := exec.Command("go", "list", "-deps", "-f", "{{.Dir}}", ".")
+	goList.Dir = k.debugBinaryPackageDir
+	out, err := goList.Output()
+	if err != nil {
+		k.log("Error running %s in %s: %s", strings.Join(goList.Args, " "), goList.Dir, err)
+		// Still, the main package is known. This is kept too, so that
+		// go list does not run again on every resolution.
+		k.debugBinaryPackageDirs = []string{k.debugBinaryPackageDir}
+		return k.debugBinaryPackageDirs
+	}
+	k.debugBinaryPackageDirs = []string{}
+	for _, dir := range strings.Split(string(out), "\n") {
+		dir = strings.TrimSpace(dir)
+		if dir != "" {
+			k.debugBinaryPackageDirs = append(k.debugBinaryPackageDirs, dir)
+		}
+	}
+	return k.debugBinaryPackageDirs
+}
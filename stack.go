@@ -0,0 +1,470 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Print values options of variable listing commands,
+// such as stack-list-locals.
+const (
+	printNoValues = iota
+	printAllValues
+	printSimpleValues
+)
+
+// maxStackDepth is the number of frames requested from Delve when
+// the whole stack is needed.
+const maxStackDepth = 1024
+
+// stacktrace returns the frames of the goroutine's stack, up to depth of them.
+func (k *kabuta) stacktrace(goroutineID int64, depth int) ([]api.Stackframe, error) {
+	return k.stacktraceFrames(rpc2.StacktraceIn{Id: goroutineID, Depth: depth})
+}
+
+// fullStacktrace is like stacktrace(), except the arguments and local
+// variables of the frames are loaded as well, all in one Delve request.
+func (k *kabuta) fullStacktrace(goroutineID int64, depth int) ([]api.Stackframe, error) {
+	return k.stacktraceFrames(rpc2.StacktraceIn{Id: goroutineID, Depth: depth, Full: true, Cfg: k.loadConfig})
+}
+
+// stacktraceFrames returns the frames Delve's Stacktrace returns.
+func (k *kabuta) stacktraceFrames(in rpc2.StacktraceIn) ([]api.Stackframe, error) {
+	goroutineID, depth := in.Id, in.Depth
+	if k.dlvRpcClient == nil {
+		return nil, NewError("No stack.")
+	}
+	out := rpc2.StacktraceOut{}
+	err := k.dlvRpcClient.Call("RPCServer.Stacktrace", in, &out)
+	if err != nil {
+		return nil, NewError("Error getting stack of goroutine %d: %s", goroutineID, err)
+	}
+	frames := out.Locations
+	// Delve returns frames 0 through depth.
+	if len(frames) > depth {
+		frames = frames[:depth]
+	}
+	return frames, nil
+}
+
+// selectedStackframe returns the selected frame of the selected goroutine.
+func (k *kabuta) selectedStackframe() (api.Stackframe, error) {
+	scope := k.evalScope()
+	frames, err := k.stacktrace(scope.GoroutineID, scope.Frame+1)
+	if err != nil {
+		return api.Stackframe{}, err
+	}
+	if scope.Frame >= len(frames) {
+		return api.Stackframe{}, NewError("No frame selected.")
+	}
+	return frames[scope.Frame], nil
+}
+
+// selectFrame makes the frame at the level the selected frame
+// of the selected goroutine and returns it.
+func (k *kabuta) selectFrame(level int) (api.Stackframe, error) {
+	frames, err := k.stacktrace(k.evalScope().GoroutineID, level+1)
+	if err != nil {
+		return api.Stackframe{}, err
+	}
+	if level < 0 || level >= len(frames) {
+		return api.Stackframe{}, NewError("No frame at level %d.", level)
+	}
+	k.selectedFrame = level
+	k.selectionGeneration++
+	return frames[level], nil
+}
+
+// notifyThreadSelected sends =thread-selected notification for the
+// selected thread and frame, which GDB does when the selection is
+// changed by a CLI command, so that the frontend's views follow it.
+func (k *kabuta) notifyThreadSelected(frame api.Stackframe) {
+	k.sendNotifyRecord("thread-selected", f("id=\"%d\",frame={level=\"%d\",%s}", k.currentThreadID(), k.selectedFrame, miFrame(frame.Location)))
+}
+
+// describeFrame prints the frame to the console the way GDB's frame
+// command does, followed by its source line if the source is available:
+// #1  0x000000000104a1c5 in main.foo () at /src/cli/main.go:12
+// 12		foo()
+func (c *gdbCmd) describeFrame(level int, frame api.Stackframe) {
+	loc := frame.Location
+	addr := ""
+	if level > 0 {
+		addr = f("0x%016x in ", loc.PC)
+	}
+	c.sendConsoleStreamRecord("#%-2d %s%s () at %s:%d\n", level, addr, functionName(loc), loc.File, loc.Line)
+	lines, err := readLines(loc.File)
+	if err == nil && loc.Line > 0 && loc.Line <= len(lines) {
+		c.sendConsoleStreamRecord("%d\t%s\n", loc.Line, lines[loc.Line-1])
+	}
+}
+
+// selectFrame selects the frame at the level on behalf of the CLI
+// commands frame, up and down, printing the frame and
+// notifying the frontend of the change.
+func (c *gdbCmd) selectFrame(level int) gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	prev := k.selectedFrame
+	frame, err := k.selectFrame(level)
+	if err != nil {
+		return returnError(err)
+	}
+	c.describeFrame(level, frame)
+	if level != prev {
+		k.notifyThreadSelected(frame)
+	}
+	return noopReturner()
+}
+
+// frameCount parses the optional number of frames to move by
+// of up and down CLI commands.
+func (c *gdbCmd) frameCount() (int, gdbMiResponse) {
+	if len(c.args) == 0 {
+		return 1, noopReturner()
+	}
+	n, err := strconv.Atoi(c.args[0])
+	if err != nil {
+		return 0, returnErrorf("Invalid number \"%s\".", c.args[0])
+	}
+	return n, noopReturner()
+}
+
+// Down is invoked in response to down CLI command.
+// It selects the frame called by the selected one (or, with an argument,
+// the frame that many levels further in).
+func (c *gdbCmd) Down() gdbMiResponse {
+	n, resp := c.frameCount()
+	if resp.err != nil {
+		return resp
+	}
+	k := c.frontendRequest.kabuta
+	if k.selectedFrame == 0 && n > 0 {
+		return returnErrorf("Bottom (innermost) frame selected; you cannot go down.")
+	}
+	level := k.selectedFrame - n
+	if level < 0 {
+		level = 0
+	}
+	return c.selectFrame(level)
+}
+
+// Frame is invoked in response to frame CLI command.
+// Without arguments, it prints the selected frame; given a level
+// (optionally preceded by the "level" keyword), it selects the frame.
+func (c *gdbCmd) Frame() gdbMiResponse {
+	args := c.args
+	if len(args) > 0 && args[0] == "level" {
+		args = args[1:]
+	}
+	k := c.frontendRequest.kabuta
+	if len(args) == 0 {
+		frame, err := k.selectedStackframe()
+		if err != nil {
+			return returnError(err)
+		}
+		c.describeFrame(k.selectedFrame, frame)
+		return noopReturner()
+	}
+	level, err := strconv.Atoi(args[0])
+	if err != nil {
+		return returnErrorf("Invalid frame level \"%s\".", args[0])
+	}
+	return c.selectFrame(level)
+}
+
+// parsePrintValues parses the print values argument of variable listing
+// commands, given either as a number or as an option (e.g., 1 or --all-values).
+func parsePrintValues(arg string) (int, error) {
+	switch arg {
+	case "0", "--no-values":
+		return printNoValues, nil
+	case "1", "--all-values":
+		return printAllValues, nil
+	case "2", "--simple-values":
+		return printSimpleValues, nil
+	}
+	return 0, NewError("Unknown value for PRINT_VALUES: must be: 0 or \"--no-values\", 1 or \"--all-values\", 2 or \"--simple-values\"")
+}
+
+// variableArgs removes the options irrelevant to Delve
+// (frame filters, unavailable values) from arguments of
+// variable listing commands, and parses the print values argument.
+func (c *gdbCmd) variableArgs() (int, []string, gdbMiResponse) {
+	var args []string
+	for _, arg := range c.args {
+		if arg != "--no-frame-filters" && arg != "--skip-unavailable" {
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 {
+		return 0, nil, returnErrorf("-%s: Usage: PRINT_VALUES", c.cmd)
+	}
+	printValues, err := parsePrintValues(args[0])
+	if err != nil {
+		return 0, nil, returnError(err)
+	}
+	return printValues, args[1:], noopReturner()
+}
+
+// isSimpleValue returns true if the variable's value is printed with
+// --simple-values, i.e., it is not an array, slice, struct, map or interface.
+func isSimpleValue(v api.Variable) bool {
+	switch v.Kind {
+	case reflect.Array, reflect.Slice, reflect.Struct, reflect.Map, reflect.Interface:
+		return false
+	}
+	return true
+}
+
+// miVariable formats the variable as a GDB/MI tuple according to
+// printValues, e.g., {name="x",type="int",value="42"}. Function
+// arguments are marked with arg="1" if isArg is true.
+func miVariable(v api.Variable, printValues int, isArg bool) string {
+	results := "name=" + miQuote(v.Name)
+	if isArg {
+		results += ",arg=\"1\""
+	}
+	if printValues == printSimpleValues {
+		results += ",type=" + miQuote(v.Type)
+	}
+	if printValues == printAllValues || (printValues == printSimpleValues && isSimpleValue(v)) {
+		results += ",value=" + miQuote(v.SinglelineString())
+	}
+	return "{" + results + "}"
+}
+
+// miVariables formats the variables as a GDB/MI list. Without values,
+// GDB lists just the names, e.g., [name="x",name="y"].
+func miVariables(vars []api.Variable, printValues int) string {
+	elts := make([]string, len(vars))
+	for i, v := range vars {
+		if printValues == printNoValues {
+			elts[i] = "name=" + miQuote(v.Name)
+		} else {
+			elts[i] = miVariable(v, printValues, false)
+		}
+	}
+	return "[" + strings.Join(elts, ",") + "]"
+}
+
+// StackInfoDepth is invoked in response to stack-info-depth GDB MI command.
+// It returns the depth of the stack, but no more than max-depth if specified:
+// 11-stack-info-depth 4
+// will return
+// 11^done,depth="4"
+// if the stack has 4 or more frames.
+func (c *gdbCmd) StackInfoDepth() gdbMiResponse {
+	maxDepth := maxStackDepth
+	if len(c.args) > 0 {
+		var err error
+		maxDepth, err = strconv.Atoi(c.args[0])
+		if err != nil || maxDepth < 0 {
+			return returnErrorf("Invalid max-depth: %s", c.args[0])
+		}
+	}
+	k := c.frontendRequest.kabuta
+	frames, err := k.stacktrace(k.evalScope().GoroutineID, maxDepth)
+	if err != nil {
+		return returnError(err)
+	}
+	return gdbMiResponse{results: f("depth=\"%d\"", len(frames))}
+}
+
+// StackInfoFrame is invoked in response to stack-info-frame GDB MI command.
+// It describes the selected frame:
+// 3^done,frame={level="1",addr="0x000000000104a1c5",func="main.foo",file="main.go",fullname="/src/cli/main.go",line="12",arch="i386:x86-64"}
+func (c *gdbCmd) StackInfoFrame() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	frame, err := k.selectedStackframe()
+	if err != nil {
+		return returnError(err)
+	}
+	return gdbMiResponse{results: f("frame={level=\"%d\",%s}", k.selectedFrame, miLocation(frame.Location))}
+}
+
+// 12^done,stack=[frame={level="0",addr="0x0000000100000df0",fp="0x0000700000080f00",
+// func="threadFunc",optimized="0",file="main.c",
+// fullname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/main.c",
+// line="6",dir="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject",
+// shlibname="/Users/grisha/g/dev/Kabuta/src/github.com/debedb/kabuta/testdata/cdtproject/a.out"},
+// frame={level="1",addr="0x00007fff93670c13",fp="0x0000700000080f20",func="_pthread_body",optimized="0",
+// shlibname="/usr/lib/system/libsystem_pthread.dylib"},frame={level="2",addr="0x00007fff93670b90",
+// fp="0x0000700000080f60",func="_pthread_start",optimized="0",
+// shlibname="/usr/lib/system/libsystem_pthread.dylib"},
+// frame={level="3",addr="0x00007fff9366e375",fp="0x0000000000000000",
+// func="thread_start",optimized="0",
+// shlibname="/usr/lib/system/libsystem_pthread.dylib"}],
+// time={wallclock="0.00411",user="0.00095",system="0.00129",
+// start="1475680391.687718",end="1475680391.691833"}
+func (c *gdbCmd) StackListFrames() gdbMiResponse {
+	// -stack-list-frames [ --no-frame-filters low-frame high-frame ]
+	// Delve has no frame filters, so there is nothing to disable.
+	var args []string
+	for _, arg := range c.args {
+		if arg != "--no-frame-filters" {
+			args = append(args, arg)
+		}
+	}
+	low := 0
+	high := maxStackDepth - 1
+	if len(args) == 1 || len(args) > 2 {
+		return returnErrorf("-stack-list-frames: Usage: [--no-frame-filters] [FRAME_LOW FRAME_HIGH]")
+	}
+	if len(args) == 2 {
+		var err1, err2 error
+		low, err1 = strconv.Atoi(args[0])
+		high, err2 = strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil || low < 0 {
+			return returnErrorf("-stack-list-frames: Invalid frame range %s %s", args[0], args[1])
+		}
+		// GDB treats -1 as "to the end".
+		if high < 0 {
+			high = maxStackDepth - 1
+		}
+	}
+	k := c.frontendRequest.kabuta
+	frames, err := k.stacktrace(k.evalScope().GoroutineID, high+1)
+	if err != nil {
+		return returnError(err)
+	}
+	if low >= len(frames) && len(args) == 2 {
+		return returnErrorf("-stack-list-frames: Not enough frames in stack.")
+	}
+	var stack []string
+	for level := low; level < len(frames) && level <= high; level++ {
+		stack = append(stack, f("frame={level=\"%d\",%s}", level, miLocation(frames[level].Location)))
+	}
+	return gdbMiResponse{results: f("stack=[%s]", strings.Join(stack, ","))}
+}
+
+// StackListArguments is invoked in response to stack-list-arguments GDB MI command.
+// It lists the arguments of the frames of the selected goroutine
+// (or of the frames from low-frame to high-frame), e.g.:
+// 5^done,stack-args=[frame={level="0",args=[{name="n",value="3"}]},frame={level="1",args=[]}]
+func (c *gdbCmd) StackListArguments() gdbMiResponse {
+	// -stack-list-arguments [ --no-frame-filters ] [ --skip-unavailable ] print-values [ low-frame high-frame ]
+	printValues, args, resp := c.variableArgs()
+	if resp.err != nil {
+		return resp
+	}
+	low := 0
+	high := maxStackDepth - 1
+	if len(args) == 1 || len(args) > 2 {
+		return returnErrorf("-stack-list-arguments: Usage: PRINT_VALUES [FRAME_LOW FRAME_HIGH]")
+	}
+	if len(args) == 2 {
+		var err1, err2 error
+		low, err1 = strconv.Atoi(args[0])
+		high, err2 = strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil || low < 0 {
+			return returnErrorf("-stack-list-arguments: Invalid frame range %s %s", args[0], args[1])
+		}
+		if high < 0 {
+			high = maxStackDepth - 1
+		}
+	}
+	k := c.frontendRequest.kabuta
+	// Rather than asking Delve for the arguments of each frame.
+	frames, err := k.fullStacktrace(k.evalScope().GoroutineID, high+1)
+	if err != nil {
+		return returnError(err)
+	}
+	var stack []string
+	for level := low; level < len(frames) && level <= high; level++ {
+		stack = append(stack, f("frame={level=\"%d\",args=%s}", level, miVariables(frames[level].Arguments, printValues)))
+	}
+	return gdbMiResponse{results: f("stack-args=[%s]", strings.Join(stack, ","))}
+}
+
+// StackListLocals is invoked in response to stack-list-locals GDB MI command.
+// It lists the local variables of the selected frame, e.g.:
+// 6^done,locals=[{name="i",type="int",value="1"},{name="s",type="[]string"}]
+func (c *gdbCmd) StackListLocals() gdbMiResponse {
+	printValues, _, resp := c.variableArgs()
+	if resp.err != nil {
+		return resp
+	}
+	k := c.frontendRequest.kabuta
+	vars, err := k.localVars(k.evalScope())
+	if err != nil {
+		return returnError(err)
+	}
+	return gdbMiResponse{results: "locals=" + miVariables(vars, printValues)}
+}
+
+// StackListVariables is invoked in response to stack-list-variables GDB MI command.
+// It lists the arguments and the local variables of the selected frame, e.g.:
+// 7^done,variables=[{name="n",arg="1",value="3"},{name="i",value="1"}]
+func (c *gdbCmd) StackListVariables() gdbMiResponse {
+	printValues, _, resp := c.variableArgs()
+	if resp.err != nil {
+		return resp
+	}
+	k := c.frontendRequest.kabuta
+	scope := k.evalScope()
+	args, err := k.functionArgs(scope)
+	if err != nil {
+		return returnError(err)
+	}
+	locals, err := k.localVars(scope)
+	if err != nil {
+		return returnError(err)
+	}
+	// Unlike the other commands, this one always lists tuples.
+	var vars []string
+	for _, v := range args {
+		vars = append(vars, miVariable(v, printValues, true))
+	}
+	for _, v := range locals {
+		vars = append(vars, miVariable(v, printValues, false))
+	}
+	return gdbMiResponse{results: f("variables=[%s]", strings.Join(vars, ","))}
+}
+
+// StackSelectFrame is invoked in response to stack-select-frame GDB MI command.
+// It selects the frame at the given level of the selected goroutine's stack.
+// Like GDB, it does not send =thread-selected, as the frontend knows of the change.
+func (c *gdbCmd) StackSelectFrame() gdbMiResponse {
+	if len(c.args) != 1 {
+		return returnErrorf("-stack-select-frame: Usage: FRAME_SPEC")
+	}
+	level, err := strconv.Atoi(c.args[0])
+	if err != nil {
+		return returnErrorf("Invalid frame level \"%s\".", c.args[0])
+	}
+	_, err = c.frontendRequest.kabuta.selectFrame(level)
+	if err != nil {
+		return returnError(err)
+	}
+	return noopReturner()
+}
+
+// Up is invoked in response to up CLI command.
+// It selects the frame that called the selected one (or, with an argument,
+// the frame that many levels further out).
+func (c *gdbCmd) Up() gdbMiResponse {
+	n, resp := c.frameCount()
+	if resp.err != nil {
+		return resp
+	}
+	k := c.frontendRequest.kabuta
+	scope := k.evalScope()
+	frames, err := k.stacktrace(scope.GoroutineID, scope.Frame+n+1)
+	if err != nil {
+		return returnError(err)
+	}
+	if scope.Frame >= len(frames)-1 && n > 0 {
+		return returnErrorf("Initial frame selected; you cannot go up.")
+	}
+	level := scope.Frame + n
+	if level > len(frames)-1 {
+		level = len(frames) - 1
+	}
+	if level < 0 {
+		level = 0
+	}
+	return c.selectFrame(level)
+}
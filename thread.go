@@ -0,0 +1,743 @@
+package kabuta
+
+import (
+	"bytes"
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// thread describes what the frontend sees as a thread: a goroutine
+// or, in threads model (see kabuta.threadModel), an OS thread.
+type thread struct {
+	id       int64
+	targetID string
+	// Start function of a goroutine, goroutine run by an OS thread.
+	name string
+	// Innermost location.
+	loc api.Location
+	// OS thread the goroutine runs on (if any), or the thread itself.
+	osThreadID int
+}
+
+// goroutines returns the goroutines of the target. Unless all is true,
+// the goroutines the filter hides (see goroutineFilters()
+// and visibleGoroutines()) are left out.
+func (k *kabuta) goroutines(all bool) ([]*api.Goroutine, error) {
+	if k.dlvRpcClient == nil {
+		return nil, NewError("The program is not being run.")
+	}
+	in := rpc2.ListGoroutinesIn{}
+	if !all {
+		in.Filters = k.goroutineFilters()
+	}
+	out := rpc2.ListGoroutinesOut{}
+	err := k.dlvRpcClient.Call("RPCServer.ListGoroutines", in, &out)
+	if err != nil {
+		return nil, NewError("Error listing goroutines: %s", err)
+	}
+	if all {
+		return out.Goroutines, nil
+	}
+	return k.visibleGoroutines(out.Goroutines), nil
+}
+
+// goroutineFilters returns the filters for Delve to list only user
+// goroutines, unless system goroutines are to be shown.
+func (k *kabuta) goroutineFilters() []api.ListGoroutinesFilter {
+	if k.showSystemGoroutines {
+		return nil
+	}
+	return []api.ListGoroutinesFilter{{Kind: api.GoroutineUser}}
+}
+
+// visibleGoroutines returns the goroutines whose start and current
+// locations do not match EnvKabutaHiddenGoroutines.
+func (k *kabuta) visibleGoroutines(goroutines []*api.Goroutine) []*api.Goroutine {
+	if k.hiddenGoroutinesRegexp == nil {
+		return goroutines
+	}
+	var visible []*api.Goroutine
+	for _, g := range goroutines {
+		if !k.hiddenLocation(g.StartLoc) && !k.hiddenLocation(g.CurrentLoc) {
+			visible = append(visible, g)
+		}
+	}
+	return visible
+}
+
+// hiddenLocation returns true if the location, as "function file:line",
+// matches EnvKabutaHiddenGoroutines.
+func (k *kabuta) hiddenLocation(loc api.Location) bool {
+	return k.hiddenGoroutinesRegexp.MatchString(f("%s %s:%d", functionName(loc), loc.File, loc.Line))
+}
+
+// osThreads returns the OS threads of the target.
+func (k *kabuta) osThreads() ([]*api.Thread, error) {
+	if k.dlvRpcClient == nil {
+		return nil, NewError("The program is not being run.")
+	}
+	out := rpc2.ListThreadsOut{}
+	err := k.dlvRpcClient.Call("RPCServer.ListThreads", rpc2.ListThreadsIn{}, &out)
+	if err != nil {
+		return nil, NewError("Error listing threads: %s", err)
+	}
+	return out.Threads, nil
+}
+
+// threads returns the threads of the target according to the thread model.
+// Unless all is true, hidden goroutines are left out (see goroutines()).
+func (k *kabuta) threads(all bool) ([]thread, error) {
+	var threads []thread
+	if k.threadModel == ThreadModelThreads {
+		osThreads, err := k.osThreads()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range osThreads {
+			threads = append(threads, osThread(t))
+		}
+		return threads, nil
+	}
+	goroutines, err := k.goroutines(all)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range goroutines {
+		threads = append(threads, goroutineThread(g))
+	}
+	return threads, nil
+}
+
+// osThread returns the OS thread as a thread.
+func osThread(t *api.Thread) thread {
+	th := thread{id: int64(t.ID), targetID: f("Thread %d", t.ID), osThreadID: t.ID}
+	if t.GoroutineID > 0 {
+		th.name = f("Goroutine %d", t.GoroutineID)
+	}
+	th.loc = api.Location{PC: t.PC, File: t.File, Line: t.Line, Function: t.Function}
+	return th
+}
+
+// thread returns the thread by ID according to the thread model, hidden
+// or not, without listing all of them. Delve cannot get a goroutine by
+// ID, so it is described from its stack, its start function being the
+// outermost one (but runtime.goexit), as Delve's StartLoc is.
+func (k *kabuta) thread(threadID int64) (thread, error) {
+	if k.dlvRpcClient == nil {
+		return thread{}, NewError("The program is not being run.")
+	}
+	if k.threadModel == ThreadModelThreads {
+		out := rpc2.GetThreadOut{}
+		err := k.dlvRpcClient.Call("RPCServer.GetThread", rpc2.GetThreadIn{Id: int(threadID)}, &out)
+		if err != nil || out.Thread == nil {
+			return thread{}, NewError("Invalid thread id: %d", threadID)
+		}
+		return osThread(out.Thread), nil
+	}
+	frames, err := k.stacktrace(threadID, maxStackDepth)
+	if err != nil || len(frames) == 0 {
+		return thread{}, NewError("Invalid thread id: %d", threadID)
+	}
+	th := thread{id: threadID, targetID: f("Goroutine %d", threadID), loc: frames[0].Location}
+	// A stack cut short does not tell the start function.
+	if bottom := frames[len(frames)-1].Location; functionName(bottom) == "runtime.goexit" {
+		for i := len(frames) - 2; i >= 0 && th.name == ""; i-- {
+			th.name = functionName(frames[i].Location)
+		}
+	}
+	// There are few OS threads, one of which may run the goroutine.
+	osThreads, err := k.osThreads()
+	if err != nil {
+		return thread{}, err
+	}
+	for _, t := range osThreads {
+		if int64(t.GoroutineID) == threadID {
+			th.osThreadID = t.ID
+		}
+	}
+	return th, nil
+}
+
+// goroutineThread returns the goroutine as a thread.
+func goroutineThread(g *api.Goroutine) thread {
+	return thread{id: g.ID, targetID: f("Goroutine %d", g.ID), name: functionName(g.StartLoc), loc: g.CurrentLoc, osThreadID: g.ThreadID}
+}
+
+// maxGoroutineGroups limits the number of goroutine groups and
+// of goroutines in each group that Delve lists.
+const maxGoroutineGroups = 1 << 20
+
+// goroutineGroup is a group of goroutines started by the same function.
+type goroutineGroup struct {
+	// ID as the frontend knows the group, e.g., g1.
+	id         string
+	function   string
+	goroutines []*api.Goroutine
+}
+
+// threads returns the goroutines of the group as threads.
+func (group goroutineGroup) threads() []thread {
+	threads := make([]thread, len(group.goroutines))
+	for i, g := range group.goroutines {
+		threads[i] = goroutineThread(g)
+	}
+	return threads
+}
+
+// goroutineGroups returns the goroutines grouped by start function,
+// leaving out hidden goroutines (see goroutines()).
+func (k *kabuta) goroutineGroups() ([]goroutineGroup, error) {
+	if k.dlvRpcClient == nil {
+		return nil, NewError("The program is not being run.")
+	}
+	in := rpc2.ListGoroutinesIn{
+		GoroutineGroupingOptions: api.GoroutineGroupingOptions{
+			GroupBy:         api.GoroutineStartLoc,
+			MaxGroupMembers: maxGoroutineGroups,
+			MaxGroups:       maxGoroutineGroups,
+		},
+		Filters: k.goroutineFilters(),
+	}
+	out := rpc2.ListGoroutinesOut{}
+	err := k.dlvRpcClient.Call("RPCServer.ListGoroutines", in, &out)
+	if err != nil {
+		return nil, NewError("Error listing goroutines: %s", err)
+	}
+	var groups []goroutineGroup
+	for _, group := range out.Groups {
+		members := k.visibleGoroutines(out.Goroutines[group.Offset : group.Offset+group.Count])
+		if len(members) == 0 {
+			continue
+		}
+		function := functionName(members[0].StartLoc)
+		// IDs stay the same as long as kabuta runs.
+		id := k.goroutineGroupIDs[function]
+		if id == "" {
+			id = f("g%d", len(k.goroutineGroupIDs)+1)
+			k.goroutineGroupIDs[function] = id
+		}
+		groups = append(groups, goroutineGroup{id: id, function: function, goroutines: members})
+	}
+	return groups, nil
+}
+
+// miThreads formats the threads as a GDB/MI list of thread tuples.
+func (k *kabuta) miThreads(threads []thread) string {
+	tids := make([]int, len(threads))
+	for i, t := range threads {
+		tids[i] = t.osThreadID
+	}
+	cores := k.threadCores(tids)
+	tuples := make([]string, len(threads))
+	for i, t := range threads {
+		tuples[i] = miThread(t, cores)
+	}
+	return "[" + strings.Join(tuples, ",") + "]"
+}
+
+// miGoroutineGroup formats the goroutine group as a GDB/MI thread group
+// tuple, with the goroutines if withThreads is true, e.g.:
+// {id="g1",type="goroutines",name="main.worker",num_children="1000"}
+func (k *kabuta) miGoroutineGroup(group goroutineGroup, withThreads bool) string {
+	result := f("id=%s,type=\"goroutines\",name=%s,num_children=\"%d\"", miQuote(group.id), miQuote(group.function), len(group.goroutines))
+	if withThreads {
+		result += ",threads=" + k.miThreads(group.threads())
+	}
+	return "{" + result + "}"
+}
+
+// inferiorPid returns the process ID of the target.
+func (k *kabuta) inferiorPid() (int, error) {
+	if k.dlvRpcClient == nil {
+		return 0, NewError("The program is not being run.")
+	}
+	out := rpc2.ProcessPidOut{}
+	err := k.dlvRpcClient.Call("RPCServer.ProcessPid", rpc2.ProcessPidIn{}, &out)
+	if err != nil {
+		return 0, err
+	}
+	return out.Pid, nil
+}
+
+// threadCores returns the cores the OS threads of the target last ran on,
+// by thread ID. Delve does not tell, but it debugs the target on this
+// machine, so on Linux they are read from /proc. Elsewhere, and for
+// goroutines not running on an OS thread, they are unknown, and so are
+// left out of both thread tuples (core) and the inferior (cores),
+// which GDB/MI allows.
+func (k *kabuta) threadCores(tids []int) map[int]int {
+	cores := make(map[int]int)
+	if runtime.GOOS != "linux" {
+		return cores
+	}
+	pid, err := k.inferiorPid()
+	if err != nil {
+		return cores
+	}
+	for _, tid := range tids {
+		if core, ok := threadCore(pid, tid); ok {
+			cores[tid] = core
+		}
+	}
+	return cores
+}
+
+// threadCore returns the core the thread of the process last ran on,
+// which is field 39 of /proc/PID/task/TID/stat.
+func threadCore(pid int, tid int) (int, bool) {
+	if tid <= 0 {
+		return 0, false
+	}
+	stat, err := ioutil.ReadFile(f("/proc/%d/task/%d/stat", pid, tid))
+	if err != nil {
+		return 0, false
+	}
+	// The command (field 2) is in parentheses and may contain spaces.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, false
+	}
+	// Fields from the state (field 3) on.
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 37 {
+		return 0, false
+	}
+	core, err := strconv.Atoi(fields[36])
+	return core, err == nil
+}
+
+// miInferior formats the target process as GDB/MI thread group i1,
+// with the cores its threads last ran on (see threadCores()).
+func (k *kabuta) miInferior() string {
+	result := "id=\"i1\",type=\"process\""
+	pid, err := k.inferiorPid()
+	if err == nil {
+		result += f(",pid=\"%d\"", pid)
+	}
+	if k.debugBinaryPath != "" {
+		result += ",executable=" + miQuote(k.debugBinaryPath)
+	}
+	if err != nil {
+		return result
+	}
+	osThreads, err := k.osThreads()
+	if err != nil {
+		return result
+	}
+	tids := make([]int, len(osThreads))
+	for i, t := range osThreads {
+		tids[i] = t.ID
+	}
+	var cores []int
+	seen := make(map[int]bool)
+	for _, core := range k.threadCores(tids) {
+		if !seen[core] {
+			seen[core] = true
+			cores = append(cores, core)
+		}
+	}
+	if len(cores) == 0 {
+		return result
+	}
+	sort.Ints(cores)
+	quoted := make([]string, len(cores))
+	for i, core := range cores {
+		quoted[i] = f("\"%d\"", core)
+	}
+	return result + f(",cores=[%s]", strings.Join(quoted, ","))
+}
+
+// ListThreadGroups is invoked in response to list-thread-groups GDB MI command:
+// -list-thread-groups [--available] [--recurse 1] [GROUP...]
+// There is one inferior, i1, e.g.:
+// 17^done,groups=[{id="i1",type="process",pid="4242",executable="/src/cli/cli",cores=["0","1"]}]
+// Its children are threads, or, with set kabuta group-goroutines on,
+// groups of goroutines started by the same function, whose children are
+// the goroutines.
+func (c *gdbCmd) ListThreadGroups() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	recurse := false
+	var groupIDs []string
+	for i := 0; i < len(c.args); i++ {
+		switch c.args[i] {
+		case "--available":
+			// There is nothing else to attach to.
+			return gdbMiResponse{results: "groups=[]"}
+		case "--recurse":
+			if i+1 < len(c.args) {
+				i++
+				recurse = c.args[i] != "0"
+			}
+		default:
+			groupIDs = append(groupIDs, c.args[i])
+		}
+	}
+	grouped := k.groupGoroutines && k.threadModel == ThreadModelGoroutines
+	// Children of the inferior: goroutine groups or threads.
+	inferiorChildren := func() (string, error) {
+		if grouped {
+			groups, err := k.goroutineGroups()
+			if err != nil {
+				return "", err
+			}
+			tuples := make([]string, len(groups))
+			for i, group := range groups {
+				tuples[i] = k.miGoroutineGroup(group, recurse)
+				k.trackThreads(group.threads())
+			}
+			return f("groups=[%s]", strings.Join(tuples, ",")), nil
+		}
+		threads, err := k.threads(false)
+		if err != nil {
+			return "", err
+		}
+		k.trackThreads(threads)
+		return "threads=" + k.miThreads(threads), nil
+	}
+	if len(groupIDs) == 0 {
+		inferior := k.miInferior()
+		if recurse && k.dlvRpcClient != nil {
+			children, err := inferiorChildren()
+			if err != nil {
+				return returnError(err)
+			}
+			inferior += "," + children
+		}
+		return gdbMiResponse{results: f("groups=[{%s}]", inferior)}
+	}
+	if len(groupIDs) > 1 {
+		return returnErrorf("Only one thread group can be listed at a time.")
+	}
+	if groupIDs[0] == "i1" {
+		if k.dlvRpcClient == nil {
+			return gdbMiResponse{results: "threads=[]"}
+		}
+		children, err := inferiorChildren()
+		if err != nil {
+			return returnError(err)
+		}
+		return gdbMiResponse{results: children}
+	}
+	if grouped {
+		groups, err := k.goroutineGroups()
+		if err != nil {
+			return returnError(err)
+		}
+		for _, group := range groups {
+			if group.id == groupIDs[0] {
+				return gdbMiResponse{results: "threads=" + k.miThreads(group.threads())}
+			}
+		}
+	}
+	return returnErrorf("Invalid thread group id '%s'.", groupIDs[0])
+}
+
+// trackThreads records that the frontend has been shown the threads, and
+// from then on is to be notified of changes (see notifyThreadChanges()).
+func (k *kabuta) trackThreads(threads []thread) {
+	k.threadsTracked = true
+	for _, t := range threads {
+		k.knownThreadIDs[t.id] = true
+	}
+}
+
+// notifyThreadChanges notifies the frontend of the threads it has not
+// been told of (=thread-created) and of those no longer listed
+// (=thread-exited), hidden goroutines being left out (see goroutines()).
+// Once the target has exited, all the threads are gone. Until the frontend
+// lists threads, it is not notified, sparing listing them all at each stop.
+func (k *kabuta) notifyThreadChanges(exited bool) {
+	if !k.threadsTracked {
+		return
+	}
+	current := make(map[int64]bool)
+	if !exited && k.dlvRpcClient != nil {
+		threads, err := k.threads(false)
+		if err != nil {
+			k.log("notifyThreadChanges(): %s", err)
+			return
+		}
+		for _, t := range threads {
+			current[t.id] = true
+			if !k.knownThreadIDs[t.id] {
+				k.sendNotifyRecord("thread-created", f("id=\"%d\",group-id=\"i1\"", t.id))
+			}
+		}
+	}
+	var gone []int64
+	for id := range k.knownThreadIDs {
+		if !current[id] {
+			gone = append(gone, id)
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool { return gone[i] < gone[j] })
+	for _, id := range gone {
+		k.sendNotifyRecord("thread-exited", f("id=\"%d\",group-id=\"i1\"", id))
+	}
+	k.knownThreadIDs = current
+}
+
+// currentThreadID returns the ID of the selected thread as the frontend
+// knows it: the goroutine's or, in threads model, the OS thread's.
+func (k *kabuta) currentThreadID() int64 {
+	if k.threadModel == ThreadModelThreads {
+		return k.selectedThreadID
+	}
+	return k.selectedGoroutineID
+}
+
+// threadTargetID returns the target ID of the thread as GDB shows it,
+// e.g., Goroutine 1.
+func (k *kabuta) threadTargetID(threadID int64) string {
+	if k.threadModel == ThreadModelThreads {
+		return f("Thread %d", threadID)
+	}
+	return f("Goroutine %d", threadID)
+}
+
+// threadGoroutineID returns the goroutine the OS thread runs. If it runs
+// none, Delve is switched to the thread, as Delve evaluates in its current
+// thread when no goroutine is given, and -1 is returned.
+func (k *kabuta) threadGoroutineID(threadID int64) (int64, error) {
+	osThreads, err := k.osThreads()
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range osThreads {
+		if int64(t.ID) != threadID {
+			continue
+		}
+		if t.GoroutineID > 0 {
+			return t.GoroutineID, nil
+		}
+		err = k.switchThread(t.ID)
+		if err != nil {
+			return 0, err
+		}
+		return -1, nil
+	}
+	return 0, NewError("Invalid thread id: %d", threadID)
+}
+
+// switchThread makes Delve switch to the OS thread.
+func (k *kabuta) switchThread(threadID int) error {
+	out := rpc2.CommandOut{}
+	err := k.dlvRpcClient.Call("RPCServer.Command", api.DebuggerCommand{Name: api.SwitchThread, ThreadID: threadID}, &out)
+	if err != nil {
+		return NewError("Cannot switch to thread %d: %s", threadID, err)
+	}
+	k.dlvState = &out.State
+	return nil
+}
+
+// dlvThreadID returns the OS thread Delve has selected, 0 if none.
+func (k *kabuta) dlvThreadID() int {
+	if k.dlvState == nil || k.dlvState.CurrentThread == nil {
+		return 0
+	}
+	return k.dlvState.CurrentThread.ID
+}
+
+// selectGoroutine makes the goroutine the selected one, selecting its
+// innermost frame, which it returns.
+func (k *kabuta) selectGoroutine(goroutineID int64) (api.Stackframe, error) {
+	frames, err := k.stacktrace(goroutineID, 1)
+	if err != nil || len(frames) == 0 {
+		return api.Stackframe{}, NewError("Invalid thread id: %d", goroutineID)
+	}
+	k.selectedGoroutineID = goroutineID
+	k.selectedFrame = 0
+	k.selectionGeneration++
+	return frames[0], nil
+}
+
+// selectThread makes the thread (see currentThreadID()) the selected one,
+// selecting its innermost frame, which it returns.
+func (k *kabuta) selectThread(threadID int64) (api.Stackframe, error) {
+	if k.threadModel != ThreadModelThreads {
+		return k.selectGoroutine(threadID)
+	}
+	goroutineID, err := k.threadGoroutineID(threadID)
+	if err != nil {
+		return api.Stackframe{}, err
+	}
+	frame, err := k.selectGoroutine(goroutineID)
+	if err != nil {
+		return api.Stackframe{}, NewError("Invalid thread id: %d", threadID)
+	}
+	k.selectedThreadID = threadID
+	return frame, nil
+}
+
+// switchGoroutine makes Delve switch to the selected goroutine,
+// if it is not the one Delve has selected, so that commands such as
+// next apply to it.
+func (k *kabuta) switchGoroutine() error {
+	if k.dlvState == nil || k.selectedGoroutineID <= 0 || k.selectedGoroutineID == stateGoroutineID(k.dlvState) {
+		return nil
+	}
+	out := rpc2.CommandOut{}
+	cmd := api.DebuggerCommand{Name: api.SwitchGoroutine, GoroutineID: k.selectedGoroutineID}
+	err := k.dlvRpcClient.Call("RPCServer.Command", cmd, &out)
+	if err != nil {
+		return NewError("Cannot switch to goroutine %d: %s", k.selectedGoroutineID, err)
+	}
+	k.dlvState = &out.State
+	return nil
+}
+
+// miThread formats the thread as a GDB/MI thread tuple, e.g.:
+// {id="1",target-id="Goroutine 1",name="main.main",state="stopped",frame={level="0",addr=...}}
+// The frame is the innermost one of the thread. Threads are only
+// listed while the target is stopped, so they are all stopped. The core
+// is that of the thread's OS thread in cores (see threadCores()), if known.
+func miThread(t thread, cores map[int]int) string {
+	name := ""
+	if t.name != "" {
+		name = ",name=" + miQuote(t.name)
+	}
+	core := ""
+	if c, ok := cores[t.osThreadID]; ok {
+		core = f(",core=\"%d\"", c)
+	}
+	return f("{id=\"%d\",target-id=%s%s,state=\"stopped\",frame={level=\"0\",%s}%s}", t.id, miQuote(t.targetID), name, miFrame(t.loc), core)
+}
+
+// InfoThreads is invoked in response to info threads CLI command.
+// It prints the threads as GDB does, marking the selected one:
+//
+//	  Id   Target Id                         Frame
+//	* 1    Goroutine 1 "main.main"           main.main () at /src/cli/main.go:12
+func (c *gdbCmd) InfoThreads() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	threads, err := k.threads(false)
+	if err != nil {
+		return returnError(err)
+	}
+	k.trackThreads(threads)
+	c.sendConsoleStreamRecord("  Id   %-33s Frame \n", "Target Id")
+	for _, t := range threads {
+		selected := " "
+		if t.id == k.currentThreadID() {
+			selected = "*"
+		}
+		targetID := t.targetID
+		if t.name != "" {
+			targetID += f(" \"%s\"", t.name)
+		}
+		c.sendConsoleStreamRecord("%s %-4d %-33s %s () at %s:%d\n", selected, t.id, targetID, functionName(t.loc), t.loc.File, t.loc.Line)
+	}
+	return noopReturner()
+}
+
+// Thread is invoked in response to thread CLI command. Without arguments,
+// it prints the selected thread; given a thread ID, it selects
+// the thread and notifies the frontend of the change.
+func (c *gdbCmd) Thread() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	if len(c.args) == 0 {
+		if k.currentThreadID() <= 0 {
+			return returnErrorf("No thread selected")
+		}
+		c.sendConsoleStreamRecord("[Current thread is %d (%s)]\n", k.currentThreadID(), k.threadTargetID(k.currentThreadID()))
+		return noopReturner()
+	}
+	threadID, err := strconv.ParseInt(c.args[0], 10, 64)
+	if err != nil {
+		return returnErrorf("Invalid thread ID: %s", c.args[0])
+	}
+	prevThreadID, prevFrame := k.currentThreadID(), k.selectedFrame
+	frame, err := k.selectThread(threadID)
+	if err != nil {
+		return returnError(err)
+	}
+	c.sendConsoleStreamRecord("[Switching to thread %d (%s)]\n", threadID, k.threadTargetID(threadID))
+	c.describeFrame(0, frame)
+	if threadID != prevThreadID || prevFrame != 0 {
+		k.notifyThreadSelected(frame)
+	}
+	return noopReturner()
+}
+
+// ThreadInfo is invoked in response to thread-info GDB MI command.
+// It describes the threads except for hidden ones (or the one given,
+// hidden or not), e.g.:
+// 14^done,threads=[{id="1",target-id="Goroutine 1",name="main.main",state="stopped",frame={...}}],current-thread-id="1"
+func (c *gdbCmd) ThreadInfo() gdbMiResponse {
+	if len(c.args) > 1 {
+		return returnErrorf("Invalid MI command")
+	}
+	k := c.frontendRequest.kabuta
+	var threads []thread
+	if len(c.args) == 1 {
+		threadID, err := strconv.ParseInt(c.args[0], 10, 64)
+		if err != nil || threadID <= 0 {
+			return returnErrorf("Invalid thread id: %s", c.args[0])
+		}
+		// A thread asked for by ID is described even if hidden.
+		t, err := k.thread(threadID)
+		if err != nil {
+			return returnError(err)
+		}
+		threads = []thread{t}
+	} else {
+		var err error
+		threads, err = k.threads(false)
+		if err != nil {
+			return returnError(err)
+		}
+		k.trackThreads(threads)
+	}
+	results := "threads=" + k.miThreads(threads)
+	if threadID := k.currentThreadID(); threadID > 0 {
+		results += f(",current-thread-id=\"%d\"", threadID)
+	}
+	return gdbMiResponse{results: results}
+}
+
+// ThreadListIds is invoked in response to thread-list-ids GDB MI command.
+// It lists IDs of goroutines, as these are the threads of interest to the user
+// (or of OS threads in threads model), except for hidden ones:
+// 15^done,thread-ids={thread-id="1",thread-id="2"},current-thread-id="1",number-of-threads="2"
+func (c *gdbCmd) ThreadListIds() gdbMiResponse {
+	k := c.frontendRequest.kabuta
+	threads, err := k.threads(false)
+	if err != nil {
+		return returnError(err)
+	}
+	k.trackThreads(threads)
+	ids := make([]string, len(threads))
+	for i, t := range threads {
+		ids[i] = f("thread-id=\"%d\"", t.id)
+	}
+	results := f("thread-ids={%s}", strings.Join(ids, ","))
+	if threadID := k.currentThreadID(); threadID > 0 {
+		results += f(",current-thread-id=\"%d\"", threadID)
+	}
+	results += f(",number-of-threads=\"%d\"", len(threads))
+	return gdbMiResponse{results: results}
+}
+
+// ThreadSelect is invoked in response to thread-select GDB MI command.
+// It selects the thread, which is where expressions are evaluated
+// and which Delve switches to before the target is resumed, e.g.:
+// 16^done,new-thread-id="2",frame={level="0",addr=...}
+func (c *gdbCmd) ThreadSelect() gdbMiResponse {
+	if len(c.args) != 1 {
+		return returnErrorf("-thread-select: USAGE: threadnum.")
+	}
+	threadID, err := strconv.ParseInt(c.args[0], 10, 64)
+	if err != nil {
+		return returnErrorf("Invalid thread id: %s", c.args[0])
+	}
+	frame, err := c.frontendRequest.kabuta.selectThread(threadID)
+	if err != nil {
+		return returnError(err)
+	}
+	return gdbMiResponse{results: f("new-thread-id=\"%d\",frame={level=\"0\",%s}", threadID, miFrame(frame.Location))}
+}
@@ -10,6 +10,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"sync"
 )
@@ -22,7 +23,7 @@ var (
 )
 
 func returnErrorf(s string, args ...interface{}) gdbMiResponse {
-	return gdbMiResponse{err: NewError(s, args)}
+	return gdbMiResponse{err: NewError(s, args...)}
 }
 
 func returnError(err error) gdbMiResponse {
@@ -31,35 +32,176 @@ func returnError(err error) gdbMiResponse {
 
 func MakeGdbResult(x interface{}) string {
 	switch x.(type) {
-	// TODO this really calls for a default fallthrough behavior
-	// being a part of switch statement. I've written too many of these...
-	case int:
-		fallthrough
-	case int8:
-		fallthrough
-	case int16:
-		fallthrough
-	case int32:
-		fallthrough
-	case int64:
+	case int, int8, int16, int32, int64:
 		return f("\"%d\"", x)
 	case string:
 		return f("\"%s\"", x)
 	default:
-		t := reflect.TypeOf(x)
-		switch t.Kind() {
-		case reflect.Map:
-			panic("Don't know how to deal with %T %s", x, t)
-		case reflect.Array:
-			panic("Don't know how to deal with %T %s", x, t)
-		case reflect.Slice:
-			panic("Don't know how to deal with %T %s", x, t)
-		case reflect.Struct:
-			panic("Don't know how to deal with %T %s", x, t)
+		panic(f("Don't know how to deal with %T %s", x, reflect.TypeOf(x)))
+	}
+}
+
+// miQuote returns s as a GDB/MI c-string, that is, quoted and
+// with special characters escaped.
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Output-Syntax.html
+func miQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString("\\\"")
+		case '\\':
+			b.WriteString("\\\\")
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// splitArgs splits command arguments on whitespace, treating
+// double-quoted c-strings (e.g., -c "i == 1") as single arguments
+// and unescaping them.
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Input-Syntax.html
+func splitArgs(argsStr string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	inQuotes := false
+	escaped := false
+	for _, r := range argsStr {
+		switch {
+		case escaped:
+			switch r {
+			case 'n':
+				arg.WriteRune('\n')
+			case 'r':
+				arg.WriteRune('\r')
+			case 't':
+				arg.WriteRune('\t')
+			default:
+				arg.WriteRune(r)
+			}
+			escaped = false
+		case inQuotes && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			inArg = true
+		case !inQuotes && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
 		default:
-			panic("Don't know how to deal with %T %s", x, t)
+			arg.WriteRune(r)
+			inArg = true
 		}
 	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// readLines returns the lines of the file.
+func readLines(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// scanTopLevel calls fn with the position of each character of the Go
+// expression that is neither within brackets nor in a string or character
+// literal (brackets themselves are skipped), until fn returns false.
+func scanTopLevel(expr string, fn func(i int, r rune) bool) {
+	depth := 0
+	var quote rune
+	escaped := false
+	for i, r := range expr {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == '\\' && quote != '`' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case r == '(' || r == '[' || r == '{':
+			depth++
+		case r == ')' || r == ']' || r == '}':
+			depth--
+		case depth == 0:
+			if !fn(i, r) {
+				return
+			}
+		}
+	}
+}
+
+// boolToInt returns 1 for true and 0 for false, as GDB/MI
+// represents booleans (e.g., has_more="1").
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// onOff renders the boolean setting as GDB does.
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// parseOnOff parses the boolean setting given as on or off.
+func parseOnOff(s string) (bool, error) {
+	switch s {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, NewError("\"on\" or \"off\" expected.")
+}
+
+// gdbArch returns the name GDB uses for the architecture
+// of the target (assumed to be the same as kabuta's).
+func gdbArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "i386:x86-64"
+	case "386":
+		return "i386"
+	case "arm64":
+		return "aarch64"
+	case "ppc64le":
+		return "powerpc:common64"
+	default:
+		return runtime.GOARCH
+	}
 }
 
 // Environ is similar to os.Environ() but
@@ -109,7 +251,7 @@ func initConfig() error {
 	var err error
 	env := Environ()
 	config = make(map[string]string)
-	envVars := []string{EnvKabutaDlvPath, EnvKabutaLogFile, EnvKabutaDlvPort, EnvKabutaPath}
+	envVars := []string{EnvKabutaDlvPath, EnvKabutaLogFile, EnvKabutaDlvPort, EnvKabutaPath, EnvKabutaThreadModel, EnvKabutaShowSystemGoroutines, EnvKabutaHiddenGoroutines}
 	for _, k := range envVars {
 		config[k] = env[k]
 	}
@@ -0,0 +1,945 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// varobj is a GDB variable object, through which frontends such as CDT
+// show variables and expressions and their children (struct fields,
+// elements, etc.).
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Variable-Objects.html
+type varobj struct {
+	// Name the frontend knows the varobj by: var1 for the root
+	// varobj, var1.field, var1.0 etc. for its children.
+	name string
+	// Expression shown to the user: the one the root varobj was
+	// created with, field name, index, etc. for children.
+	exp string
+	// Go expression evaluating to the varobj's value,
+	// e.g., (*s.users[3]).Name (see childPath()).
+	path   string
+	parent *varobj
+	// Index of the varobj's variable among childVariables() of the parent.
+	index int
+	// Children, nil if not listed yet.
+	children []*varobj
+	// Scope a root varobj is evaluated in. Floating varobjs
+	// are evaluated in the selected frame instead.
+	scope    api.EvalScope
+	floating bool
+	// Thread (see kabuta.currentThreadID()) a root varobj is evaluated in.
+	threadID int64
+	// The frame of the scope of a root varobj, identified by its level
+	// counting from the outermost frame (as the frames called since
+	// the varobj was created come on top of it) and its function.
+	// If frameFromBottom is -1, the varobj does not depend on a frame.
+	frameFromBottom int
+	function        string
+	// Display format (see format.go), inherited by children.
+	format string
+	// Range of children set by -var-set-update-range.
+	rangeSet  bool
+	rangeFrom int
+	rangeTo   int
+	// Value as of the last evaluation.
+	variable *api.Variable
+	// Value as last reported to the frontend, and whether the varobj
+	// was in scope then (see -var-update).
+	value   string
+	inScope bool
+}
+
+// root returns the root varobj of the varobj's tree.
+func (vo *varobj) root() *varobj {
+	for vo.parent != nil {
+		vo = vo.parent
+	}
+	return vo
+}
+
+// render renders the variable's value in the varobj's format.
+func (vo *varobj) render(v *api.Variable) string {
+	if s, ok := formatInteger(v, vo.format); ok {
+		return s
+	}
+	return varobjValue(v)
+}
+
+// rootThreadID returns the ID of the thread the varobj is evaluated in.
+func (vo *varobj) rootThreadID() int64 {
+	return vo.root().threadID
+}
+
+// createVarobj creates a root varobj for the expression. The name is
+// generated if empty.
+func (k *kabuta) createVarobj(name string, expr string, floating bool) (*varobj, error) {
+	if name == "" {
+		for {
+			k.lastVarobjNo++
+			name = f("var%d", k.lastVarobjNo)
+			if k.varobjs[name] == nil {
+				break
+			}
+		}
+	} else if k.varobjs[name] != nil {
+		return nil, NewError("Duplicate variable object name")
+	}
+	scope := k.evalScope()
+	v, err := k.eval(expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	vo := &varobj{name: name, exp: expr, path: expr, scope: scope, floating: floating, threadID: k.currentThreadID(), format: formatNatural, variable: v, inScope: true}
+	vo.value = vo.render(v)
+	vo.frameFromBottom = -1
+	frames, err := k.stacktrace(scope.GoroutineID, maxStackDepth)
+	if err == nil && scope.Frame < len(frames) {
+		vo.frameFromBottom = len(frames) - scope.Frame
+		vo.function = functionName(frames[scope.Frame].Location)
+	}
+	k.varobjs[name] = vo
+	return vo, nil
+}
+
+// varobjScope returns the scope the root varobj is to be evaluated in
+// now, or false if its frame is gone. The stacks of goroutines are cached
+// in stacks, so that Delve is asked for each stack once per update.
+func (k *kabuta) varobjScope(root *varobj, stacks map[int64][]api.Stackframe) (api.EvalScope, bool) {
+	if root.floating {
+		root.scope = k.evalScope()
+		root.threadID = k.currentThreadID()
+		return root.scope, true
+	}
+	if root.frameFromBottom < 0 {
+		return root.scope, true
+	}
+	goroutineID := root.scope.GoroutineID
+	frames, ok := stacks[goroutineID]
+	if !ok {
+		// The goroutine may have exited, in which case there is no stack.
+		frames, _ = k.stacktrace(goroutineID, maxStackDepth)
+		stacks[goroutineID] = frames
+	}
+	level := len(frames) - root.frameFromBottom
+	if level < 0 || level >= len(frames) || functionName(frames[level].Location) != root.function {
+		return api.EvalScope{}, false
+	}
+	root.scope.Frame = level
+	return root.scope, true
+}
+
+// varobj returns the varobj by name.
+func (k *kabuta) varobj(name string) (*varobj, error) {
+	vo := k.varobjs[name]
+	if vo == nil {
+		return nil, NewError("Variable object not found")
+	}
+	return vo, nil
+}
+
+// deleteVarobj deletes the varobj's children and, unless childrenOnly
+// is true, the varobj itself. It returns the number of varobjs deleted.
+func (k *kabuta) deleteVarobj(vo *varobj, childrenOnly bool) int {
+	deleted := 0
+	for _, child := range vo.children {
+		if child != nil {
+			deleted += k.deleteVarobj(child, false)
+		}
+	}
+	vo.children = nil
+	if !childrenOnly {
+		delete(k.varobjs, vo.name)
+		deleted++
+	}
+	return deleted
+}
+
+// varobjChildren returns the varobj's children from index from to index
+// to (exclusive), creating those not created yet (or deleted since) from
+// the varobj's variable. Elements beyond those Delve loaded along with
+// the varobj are loaded for the range only (see childrenWindow()).
+// The slice returned has nil for children that could not be loaded.
+func (k *kabuta) varobjChildren(vo *varobj, from int, to int) ([]*varobj, error) {
+	for len(vo.children) < to {
+		vo.children = append(vo.children, nil)
+	}
+	complete := true
+	for _, child := range vo.children[from:to] {
+		complete = complete && child != nil
+	}
+	if complete {
+		return vo.children[from:to], nil
+	}
+	v := vo.variable
+	offset := 0
+	if isSequence(v) && to > len(childVariables(v)) {
+		var err error
+		v, err = k.childrenWindow(vo, from, to)
+		if err != nil {
+			return nil, err
+		}
+		offset = from
+	} else if !loaded(v) {
+		// Beyond what Delve loaded along with the root; load it by itself.
+		scope, err := k.childrenScope(vo)
+		if err != nil {
+			return nil, err
+		}
+		v, err = k.eval(vo.path, scope)
+		if err != nil {
+			return nil, err
+		}
+		vo.variable = v
+	}
+	vars := childVariables(v)
+	for index := from; index < to && index-offset < len(vars); index++ {
+		if vo.children[index] != nil {
+			continue
+		}
+		i := index - offset
+		exp, suffix := childExp(v, vo.exp, i, index)
+		child := &varobj{
+			name:     vo.name + "." + suffix,
+			exp:      exp,
+			path:     childPath(v, vo.path, i, index),
+			parent:   vo,
+			index:    index,
+			format:   vo.format,
+			variable: &vars[i],
+			inScope:  true,
+		}
+		child.value = child.render(child.variable)
+		k.varobjs[child.name] = child
+		vo.children[index] = child
+	}
+	return vo.children[from:to], nil
+}
+
+// isSequence returns true if the variable's children are elements
+// (of an array, slice or map), which may be too many to load at once.
+func isSequence(v *api.Variable) bool {
+	switch v.Kind {
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// childrenWindow loads the elements of the sequence varobj from index
+// from to index to (exclusive) by evaluating a slice expression, such
+// as s[1000:2000], with LoadConfig allowing for that many elements.
+// Delve slices maps as well, but only takes the low index for them.
+func (k *kabuta) childrenWindow(vo *varobj, from int, to int) (*api.Variable, error) {
+	cfg := *k.loadConfig
+	cfg.MaxArrayValues = to - from
+	expr := f("%s[%d:%d]", parenthesize(vo.path), from, to)
+	if vo.variable.Kind == reflect.Map {
+		expr = f("%s[%d:]", parenthesize(vo.path), from)
+	}
+	scope, err := k.childrenScope(vo)
+	if err != nil {
+		return nil, err
+	}
+	return k.evalWithConfig(expr, scope, cfg)
+}
+
+// childrenScope returns the scope in which children of the varobj are
+// loaded, which is that of its root as var-update would evaluate it
+// (see varobjScope()): the selected frame for a floating varobj.
+func (k *kabuta) childrenScope(vo *varobj) (api.EvalScope, error) {
+	scope, inScope := k.varobjScope(vo.root(), make(map[int64][]api.Stackframe))
+	if !inScope {
+		return api.EvalScope{}, NewError("Variable object %s is not in scope.", vo.root().name)
+	}
+	return scope, nil
+}
+
+// updateRange returns the range of the varobj's children that
+// -var-update re-evaluates: those set by -var-set-update-range,
+// or else all of them.
+func (vo *varobj) updateRange() (int, int) {
+	from, to := 0, len(vo.children)
+	if vo.rangeSet {
+		if vo.rangeFrom > from {
+			from = vo.rangeFrom
+		}
+		if vo.rangeTo < to {
+			to = vo.rangeTo
+		}
+	}
+	return from, to
+}
+
+// hasMore returns true if the varobj has children beyond
+// the range set by -var-set-update-range.
+func (vo *varobj) hasMore() bool {
+	return vo.rangeSet && vo.rangeTo < numChildren(vo.variable)
+}
+
+// isNilVariable returns true if the variable is a nil pointer or interface.
+func isNilVariable(v *api.Variable) bool {
+	switch v.Kind {
+	case reflect.Ptr:
+		return len(v.Children) == 0 || v.Children[0].Addr == 0
+	case reflect.Interface:
+		return len(v.Children) == 0 || v.Children[0].Kind == reflect.Invalid
+	}
+	return false
+}
+
+// pointee returns the variable a non-nil pointer or interface points to.
+func pointee(v *api.Variable) *api.Variable {
+	return &v.Children[0]
+}
+
+// derefStruct returns true if the children of the variable
+// are the fields of the struct it points to, as in GDB.
+func derefStruct(v *api.Variable) bool {
+	return v.Kind == reflect.Ptr && !isNilVariable(v) && pointee(v).Kind == reflect.Struct
+}
+
+// loaded returns true if Delve loaded the variable's children
+// (it does not beyond LoadConfig limits).
+func loaded(v *api.Variable) bool {
+	switch v.Kind {
+	case reflect.Ptr, reflect.Interface:
+		if isNilVariable(v) {
+			return true
+		}
+		p := pointee(v)
+		return !p.OnlyAddr && (v.Kind == reflect.Interface || p.Kind != reflect.Struct || loaded(p))
+	case reflect.Struct:
+		return int64(len(v.Children)) >= v.Len
+	case reflect.Array, reflect.Slice:
+		return int64(len(v.Children)) >= v.Len
+	case reflect.Map:
+		return int64(len(v.Children)) >= 2*v.Len
+	}
+	return true
+}
+
+// numChildren returns the number of children of the variable.
+func numChildren(v *api.Variable) int {
+	switch v.Kind {
+	case reflect.Struct, reflect.Array, reflect.Slice, reflect.Map:
+		return int(v.Len)
+	case reflect.Ptr:
+		if isNilVariable(v) {
+			return 0
+		}
+		if derefStruct(v) {
+			return int(pointee(v).Len)
+		}
+		return 1
+	case reflect.Interface:
+		if isNilVariable(v) {
+			return 0
+		}
+		return 1
+	}
+	return 0
+}
+
+// childVariables returns the variables of the variable's children:
+// struct fields, array, slice and map elements, the value a pointer
+// points to (or its fields if it is a struct) and the concrete value
+// of an interface.
+func childVariables(v *api.Variable) []api.Variable {
+	switch v.Kind {
+	case reflect.Struct, reflect.Array, reflect.Slice:
+		return v.Children
+	case reflect.Map:
+		// Delve lists keys and values alternately.
+		values := make([]api.Variable, len(v.Children)/2)
+		for i := range values {
+			values[i] = v.Children[2*i+1]
+		}
+		return values
+	case reflect.Ptr:
+		if isNilVariable(v) {
+			return nil
+		}
+		if derefStruct(v) {
+			return pointee(v).Children
+		}
+		return v.Children[:1]
+	case reflect.Interface:
+		if isNilVariable(v) {
+			return nil
+		}
+		return v.Children[:1]
+	}
+	return nil
+}
+
+// childExp returns the expression shown for the child of the variable at
+// i, given the expression shown for the variable, and the suffix of the
+// child's varobj name (which may differ from the expression, e.g., for map
+// keys that are strings). For elements, index is the index of the child
+// in the whole sequence, of which the variable may be a window.
+func childExp(v *api.Variable, exp string, i int, index int) (string, string) {
+	switch v.Kind {
+	case reflect.Struct:
+		return v.Children[i].Name, v.Children[i].Name
+	case reflect.Map:
+		return v.Children[2*i].SinglelineString(), strconv.Itoa(index)
+	case reflect.Ptr:
+		if derefStruct(v) {
+			name := pointee(v).Children[i].Name
+			return name, name
+		}
+		return "*" + exp, "*"
+	case reflect.Interface:
+		return pointee(v).Type, "data"
+	}
+	return strconv.Itoa(index), strconv.Itoa(index)
+}
+
+// childPath returns the Go expression evaluating to the child of the
+// variable at i, given the expression of the variable (see childExp()
+// for index).
+func childPath(v *api.Variable, path string, i int, index int) string {
+	switch v.Kind {
+	case reflect.Struct:
+		return f("%s.%s", parenthesize(path), v.Children[i].Name)
+	case reflect.Array, reflect.Slice:
+		return f("%s[%d]", parenthesize(path), index)
+	case reflect.Map:
+		return f("%s[%s]", parenthesize(path), v.Children[2*i].SinglelineString())
+	case reflect.Ptr:
+		if derefStruct(v) {
+			return f("(*%s).%s", parenthesize(path), pointee(v).Children[i].Name)
+		}
+		return "*" + parenthesize(path)
+	case reflect.Interface:
+		return f("%s.(%s)", parenthesize(path), pointee(v).Type)
+	}
+	return path
+}
+
+// parenthesize puts the expression in parentheses unless it is
+// a primary expression (an identifier, selector, index, etc.), so
+// that it can be used as an operand.
+func parenthesize(expr string) string {
+	operator := false
+	scanTopLevel(expr, func(i int, r rune) bool {
+		operator = strings.ContainsRune(" *&+-/%<>=!|^", r)
+		return !operator
+	})
+	if operator {
+		return "(" + expr + ")"
+	}
+	return expr
+}
+
+// editable returns true if Delve can assign the varobj: it has to be
+// a boolean, number or pointer stored in memory. Elements of maps and
+// concrete values of interfaces are not (Delve only sees their copies).
+func (vo *varobj) editable() bool {
+	v := vo.variable
+	if !vo.inScope || v.Unreadable != "" || v.Addr == 0 {
+		return false
+	}
+	if vo.parent != nil {
+		switch vo.parent.variable.Kind {
+		case reflect.Map, reflect.Interface:
+			return false
+		}
+	}
+	switch v.Kind {
+	case reflect.Bool, reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.Ptr, reflect.UnsafePointer:
+		return true
+	}
+	return integerBits(v) > 0
+}
+
+// varobjValue renders the variable's value the way GDB renders
+// values of varobjs: composite values are summarized, as their
+// children are shown separately.
+func varobjValue(v *api.Variable) string {
+	if v.Unreadable != "" {
+		return f("<error: %s>", v.Unreadable)
+	}
+	switch v.Kind {
+	case reflect.Struct:
+		return "{...}"
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return f("[%d]", v.Len)
+	case reflect.Ptr:
+		if isNilVariable(v) {
+			return "nil"
+		}
+		return f("(%s) 0x%x", v.Type, pointee(v).Addr)
+	case reflect.Interface:
+		if isNilVariable(v) {
+			return "nil"
+		}
+		return varobjValue(pointee(v))
+	}
+	return v.SinglelineString()
+}
+
+// dynamicType returns the type of the variable's value,
+// which, for an interface, is the type of its concrete value.
+func dynamicType(v *api.Variable) string {
+	if v.Kind == reflect.Interface && !isNilVariable(v) {
+		return pointee(v).Type
+	}
+	return v.Type
+}
+
+// updateVarobj re-evaluates the varobj (and its children, if listed)
+// and returns the GDB/MI changelist entries for those that changed
+// since the last update. Only varobjs that Delve did not load
+// along with the varobj are evaluated separately, so this takes
+// one Delve request for most varobjs, no matter how many children
+// they have.
+func (k *kabuta) updateVarobj(vo *varobj, printValues int, stacks map[int64][]api.Stackframe) []string {
+	scope, inScope := k.varobjScope(vo.root(), stacks)
+	var v *api.Variable
+	if inScope {
+		var err error
+		v, err = k.eval(vo.path, scope)
+		if err != nil {
+			k.log("Cannot evaluate %s: %s", vo.path, err)
+			inScope = false
+		}
+	}
+	if !inScope {
+		if !vo.inScope {
+			return nil
+		}
+		vo.inScope = false
+		return []string{f("{name=%s,in_scope=\"false\",type_changed=\"false\",has_more=\"0\"}", miQuote(vo.name))}
+	}
+	return k.refreshVarobj(vo, v, printValues)
+}
+
+// refreshVarobj sets the new variable of the varobj and of its listed
+// children, returning the changelist entries for those that changed.
+func (k *kabuta) refreshVarobj(vo *varobj, v *api.Variable, printValues int) []string {
+	old := vo.variable
+	vo.variable = v
+	value := vo.render(v)
+	typeChanged := dynamicType(old) != dynamicType(v)
+	numChild := numChildren(v)
+	numChildChanged := numChild != numChildren(old)
+	var changes []string
+	if value != vo.value || typeChanged || numChildChanged || !vo.inScope {
+		change := "name=" + miQuote(vo.name)
+		if printValues == printAllValues || (printValues == printSimpleValues && isSimpleValue(*v)) {
+			change += ",value=" + miQuote(value)
+		}
+		change += f(",in_scope=\"true\",type_changed=\"%t\"", typeChanged)
+		if typeChanged {
+			change += ",new_type=" + miQuote(dynamicType(v))
+		}
+		if typeChanged || numChildChanged {
+			change += f(",new_num_children=\"%d\"", numChild)
+		}
+		changes = append(changes, "{"+change+f(",has_more=\"%d\"}", boolToInt(vo.hasMore())))
+	}
+	vo.value = value
+	vo.inScope = true
+	if typeChanged {
+		// Like GDB, children of the old type are gone.
+		k.deleteVarobj(vo, true)
+		return changes
+	}
+	for i, child := range vo.children {
+		if child != nil && i >= numChild {
+			k.deleteVarobj(child, false)
+			vo.children[i] = nil
+		}
+	}
+	if len(vo.children) > numChild {
+		vo.children = vo.children[:numChild]
+	}
+	from, to := vo.updateRange()
+	if from >= to {
+		return changes
+	}
+	vars := childVariables(v)
+	offset := 0
+	if isSequence(v) && to > len(vars) {
+		// Only the children in the update range are loaded.
+		window, err := k.childrenWindow(vo, from, to)
+		if err != nil {
+			k.log("Cannot evaluate children of %s: %s", vo.path, err)
+			return changes
+		}
+		vars = childVariables(window)
+		offset = from
+	} else if !loaded(v) {
+		loadedV, err := k.eval(vo.path, vo.root().scope)
+		if err == nil {
+			vo.variable = loadedV
+			vars = childVariables(loadedV)
+		}
+	}
+	for index := from; index < to; index++ {
+		child := vo.children[index]
+		i := index - offset
+		if child != nil && i < len(vars) {
+			changes = append(changes, k.refreshVarobj(child, &vars[i], printValues)...)
+		}
+	}
+	return changes
+}
+
+// miVarobj formats the varobj's attributes as GDB/MI results,
+// with the value if withValue is true, e.g.:
+// name="var1.T",exp="T",numchild="2",value="{...}",type="main.T",thread-id="1"
+// Like in GDB, only children have exp.
+func miVarobj(vo *varobj, withValue bool) string {
+	v := vo.variable
+	results := "name=" + miQuote(vo.name)
+	if vo.parent != nil {
+		results += ",exp=" + miQuote(vo.exp)
+	}
+	results += f(",numchild=\"%d\"", numChildren(v))
+	if withValue {
+		results += ",value=" + miQuote(vo.render(v))
+	}
+	results += ",type=" + miQuote(v.Type)
+	if threadID := vo.rootThreadID(); threadID > 0 {
+		results += f(",thread-id=\"%d\"", threadID)
+	}
+	return results
+}
+
+// varobjArg returns the varobj named by the only argument of the command.
+func (c *gdbCmd) varobjArg() (*varobj, gdbMiResponse) {
+	if len(c.args) != 1 {
+		return nil, returnErrorf("-%s: Usage: NAME.", c.cmd)
+	}
+	vo, err := c.frontendRequest.kabuta.varobj(c.args[0])
+	if err != nil {
+		return nil, returnError(err)
+	}
+	return vo, noopReturner()
+}
+
+// VarAssign is invoked in response to var-assign GDB MI command.
+// It assigns the value (a Go expression) to the varobj using Delve and
+// returns the value as read back, e.g.:
+// 10^done,value="42"
+func (c *gdbCmd) VarAssign() gdbMiResponse {
+	if len(c.args) < 2 {
+		return returnErrorf("-var-assign: Usage: NAME EXPRESSION.")
+	}
+	k := c.frontendRequest.kabuta
+	vo, err := k.varobj(c.args[0])
+	if err != nil {
+		return returnError(err)
+	}
+	if !vo.editable() {
+		return returnErrorf("-var-assign: Variable object is not editable")
+	}
+	scope, err := k.childrenScope(vo)
+	if err != nil {
+		return returnError(err)
+	}
+	err = k.setVariable(vo.path, strings.Join(c.args[1:], " "), scope)
+	if err != nil {
+		return returnErrorf("-var-assign: %s", err)
+	}
+	v, err := k.eval(vo.path, scope)
+	if err != nil {
+		return returnError(err)
+	}
+	// The change is not to be reported by -var-update.
+	vo.variable = v
+	vo.value = vo.render(v)
+	return gdbMiResponse{results: "value=" + miQuote(vo.value)}
+}
+
+// VarCreate is invoked in response to var-create GDB MI command:
+// -var-create {NAME | "-"} {FRAME-ADDR | "*" | "@"} EXPRESSION
+// The expression is evaluated by Delve in the selected frame. Delve cannot
+// find a frame by its address, so FRAME-ADDR is treated as "*" is.
+func (c *gdbCmd) VarCreate() gdbMiResponse {
+	if len(c.args) < 3 {
+		return returnErrorf("-var-create: Usage: NAME FRAME EXPRESSION.")
+	}
+	name := c.args[0]
+	if name == "-" {
+		name = ""
+	}
+	floating := c.args[1] == "@"
+	expr := strings.Join(c.args[2:], " ")
+	vo, err := c.frontendRequest.kabuta.createVarobj(name, expr, floating)
+	if err != nil {
+		c.frontendRequest.kabuta.log("Cannot evaluate %s: %s", expr, err)
+		return returnErrorf("-var-create: unable to create variable object")
+	}
+	return gdbMiResponse{results: miVarobj(vo, true) + ",has_more=\"0\""}
+}
+
+// VarDelete is invoked in response to var-delete GDB MI command.
+// It deletes the varobj and its children or, with -c, just the children.
+func (c *gdbCmd) VarDelete() gdbMiResponse {
+	childrenOnly := c.flagSet.Bool("c", false, "")
+	err := c.flagSet.Parse(c.args)
+	if err != nil || c.flagSet.NArg() != 1 {
+		return returnErrorf("-var-delete: Usage: [-c] EXPRESSION.")
+	}
+	k := c.frontendRequest.kabuta
+	vo, err := k.varobj(c.flagSet.Arg(0))
+	if err != nil {
+		return returnError(err)
+	}
+	deleted := k.deleteVarobj(vo, *childrenOnly)
+	if !*childrenOnly && vo.parent != nil {
+		// Recreated if the parent's children are listed again.
+		vo.parent.children[vo.index] = nil
+	}
+	return gdbMiResponse{results: f("ndeleted=\"%d\"", deleted)}
+}
+
+// VarEvaluateExpression is invoked in response to var-evaluate-expression
+// GDB MI command. It returns the varobj's value as of the last update,
+// in the format given by -f or else in the varobj's format.
+func (c *gdbCmd) VarEvaluateExpression() gdbMiResponse {
+	format := c.flagSet.String("f", "", "")
+	err := c.flagSet.Parse(c.args)
+	if err != nil || c.flagSet.NArg() != 1 {
+		return returnErrorf("-var-evaluate-expression: Usage: [-f FORMAT] NAME")
+	}
+	vo, err := c.frontendRequest.kabuta.varobj(c.flagSet.Arg(0))
+	if err != nil {
+		return returnError(err)
+	}
+	if *format == "" {
+		return gdbMiResponse{results: "value=" + miQuote(vo.render(vo.variable))}
+	}
+	*format, err = parseFormat(*format)
+	if err != nil {
+		return returnError(err)
+	}
+	if s, ok := formatInteger(vo.variable, *format); ok {
+		return gdbMiResponse{results: "value=" + miQuote(s)}
+	}
+	return gdbMiResponse{results: "value=" + miQuote(varobjValue(vo.variable))}
+}
+
+// VarInfoExpression is invoked in response to var-info-expression GDB MI command.
+// It returns the expression the varobj is shown as, e.g.:
+// 12^done,lang="Go",exp="Name"
+func (c *gdbCmd) VarInfoExpression() gdbMiResponse {
+	vo, resp := c.varobjArg()
+	if vo == nil {
+		return resp
+	}
+	return gdbMiResponse{results: "lang=\"Go\",exp=" + miQuote(vo.exp)}
+}
+
+// VarInfoNumChildren is invoked in response to var-info-num-children GDB MI command.
+func (c *gdbCmd) VarInfoNumChildren() gdbMiResponse {
+	vo, resp := c.varobjArg()
+	if vo == nil {
+		return resp
+	}
+	return gdbMiResponse{results: f("numchild=\"%d\"", numChildren(vo.variable))}
+}
+
+// VarInfoPathExpression is invoked in response to var-info-path-expression
+// GDB MI command. It returns the Go expression evaluating to the varobj's
+// value, which the frontend can evaluate by itself, e.g.:
+// 13^done,path_expr="(*s.users[3]).Name"
+func (c *gdbCmd) VarInfoPathExpression() gdbMiResponse {
+	vo, resp := c.varobjArg()
+	if vo == nil {
+		return resp
+	}
+	return gdbMiResponse{results: "path_expr=" + miQuote(vo.path)}
+}
+
+// VarInfoType is invoked in response to var-info-type GDB MI command.
+// It returns the Go type of the varobj.
+func (c *gdbCmd) VarInfoType() gdbMiResponse {
+	vo, resp := c.varobjArg()
+	if vo == nil {
+		return resp
+	}
+	return gdbMiResponse{results: "type=" + miQuote(vo.variable.Type)}
+}
+
+// VarListChildren is invoked in response to var-list-children GDB MI command:
+// -var-list-children [PRINT-VALUES] NAME [FROM TO]
+// e.g.:
+// 8^done,numchild="1",children=[child={name="var1.Name",exp="Name",numchild="0",value="\"x\"",type="string",thread-id="1"}],has_more="0"
+// Without FROM and TO, elements of arrays, slices and maps are listed
+// up to LoadConfig's MaxArrayValues, and has_more tells if there are more.
+func (c *gdbCmd) VarListChildren() gdbMiResponse {
+	args := c.args
+	printValues := printNoValues
+	if len(args) == 2 || len(args) == 4 {
+		var err error
+		printValues, err = parsePrintValues(args[0])
+		if err != nil {
+			return returnError(err)
+		}
+		args = args[1:]
+	}
+	if len(args) != 1 && len(args) != 3 {
+		return returnErrorf("-var-list-children: Usage: [PRINT_VALUES] NAME [FROM TO]")
+	}
+	k := c.frontendRequest.kabuta
+	vo, err := k.varobj(args[0])
+	if err != nil {
+		return returnError(err)
+	}
+	numChild := numChildren(vo.variable)
+	from, to := 0, numChild
+	if len(args) == 3 {
+		var err1, err2 error
+		from, err1 = strconv.Atoi(args[1])
+		to, err2 = strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return returnErrorf("-var-list-children: Invalid range %s %s", args[1], args[2])
+		}
+		if from < 0 {
+			from = 0
+		}
+		if to > numChild {
+			to = numChild
+		}
+		if from > to {
+			from = to
+		}
+	} else if isSequence(vo.variable) && to > k.loadConfig.MaxArrayValues {
+		to = k.loadConfig.MaxArrayValues
+	}
+	children, err := k.varobjChildren(vo, from, to)
+	if err != nil {
+		return returnError(err)
+	}
+	var elts []string
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		v := child.variable
+		withValue := printValues == printAllValues || (printValues == printSimpleValues && isSimpleValue(*v))
+		elts = append(elts, f("child={%s}", miVarobj(child, withValue)))
+	}
+	return gdbMiResponse{results: f("numchild=\"%d\",children=[%s],has_more=\"%d\"", len(elts), strings.Join(elts, ","), boolToInt(to < numChild))}
+}
+
+// VarSetFormat is invoked in response to var-set-format GDB MI command.
+// The format applies to integers (including in the varobj's children
+// listed later) and is kept across updates, e.g.:
+// 11^done,format="hexadecimal",value="0x1f"
+func (c *gdbCmd) VarSetFormat() gdbMiResponse {
+	if len(c.args) != 2 {
+		return returnErrorf("-var-set-format: Usage: NAME FORMAT.")
+	}
+	vo, err := c.frontendRequest.kabuta.varobj(c.args[0])
+	if err != nil {
+		return returnError(err)
+	}
+	format, err := parseFormat(c.args[1])
+	if err != nil {
+		return returnError(err)
+	}
+	vo.format = format
+	// The frontend is told the value, so it is not a change to report.
+	vo.value = vo.render(vo.variable)
+	return gdbMiResponse{results: f("format=%s,value=%s", miQuote(format), miQuote(vo.value))}
+}
+
+// VarSetUpdateRange is invoked in response to var-set-update-range GDB MI command.
+// It restricts the children -var-update re-evaluates to those from FROM
+// to TO (exclusive), so that only the elements visible in the frontend
+// are loaded. A negative FROM or TO resets the range.
+func (c *gdbCmd) VarSetUpdateRange() gdbMiResponse {
+	if len(c.args) != 3 {
+		return returnErrorf("-var-set-update-range: Usage: VAROBJ FROM TO")
+	}
+	vo, err := c.frontendRequest.kabuta.varobj(c.args[0])
+	if err != nil {
+		return returnError(err)
+	}
+	from, err1 := strconv.Atoi(c.args[1])
+	to, err2 := strconv.Atoi(c.args[2])
+	if err1 != nil || err2 != nil {
+		return returnErrorf("-var-set-update-range: Invalid range %s %s", c.args[1], c.args[2])
+	}
+	vo.rangeSet = from >= 0 && to >= 0
+	vo.rangeFrom = from
+	vo.rangeTo = to
+	return noopReturner()
+}
+
+// VarShowAttributes is invoked in response to var-show-attributes GDB MI command.
+// The varobj is editable if -var-assign can assign it (see varobj.editable()).
+func (c *gdbCmd) VarShowAttributes() gdbMiResponse {
+	vo, resp := c.varobjArg()
+	if vo == nil {
+		return resp
+	}
+	if vo.editable() {
+		return gdbMiResponse{results: "status=\"editable\""}
+	}
+	return gdbMiResponse{results: "status=\"noneditable\""}
+}
+
+// VarShowFormat is invoked in response to var-show-format GDB MI command.
+func (c *gdbCmd) VarShowFormat() gdbMiResponse {
+	vo, resp := c.varobjArg()
+	if vo == nil {
+		return resp
+	}
+	return gdbMiResponse{results: "format=" + miQuote(vo.format)}
+}
+
+// VarUpdate is invoked in response to var-update GDB MI command:
+// -var-update [PRINT-VALUES] {NAME | "*"}
+// It re-evaluates the varobj (or all of them) and reports those that changed, e.g.:
+// 9^done,changelist=[{name="var1.0",value="2",in_scope="true",type_changed="false",has_more="0"}]
+func (c *gdbCmd) VarUpdate() gdbMiResponse {
+	args := c.args
+	printValues := printNoValues
+	if len(args) > 1 {
+		var err error
+		printValues, err = parsePrintValues(args[0])
+		if err != nil {
+			return returnError(err)
+		}
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return returnErrorf("-var-update: Usage: [PRINT_VALUES] VAROBJ_NAME.")
+	}
+	k := c.frontendRequest.kabuta
+	var vos []*varobj
+	if args[0] == "*" {
+		var names []string
+		for name, vo := range k.varobjs {
+			if vo.parent == nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			vos = append(vos, k.varobjs[name])
+		}
+	} else {
+		vo, err := k.varobj(args[0])
+		if err != nil {
+			return returnError(err)
+		}
+		vos = append(vos, vo)
+	}
+	stacks := make(map[int64][]api.Stackframe)
+	var changes []string
+	for _, vo := range vos {
+		changes = append(changes, k.updateVarobj(vo, printValues, stacks)...)
+	}
+	return gdbMiResponse{results: f("changelist=[%s]", strings.Join(changes, ","))}
+}
@@ -0,0 +1,168 @@
+package kabuta
+
+import (
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/rpc2"
+	"strings"
+)
+
+// BreakWatch is invoked in response to break-watch GDB MI command.
+// It creates a Delve watchpoint on the expression evaluated in the current scope:
+// write watchpoint by default, read watchpoint with -r and access (read or write)
+// watchpoint with -a.
+// See https://sourceware.org/gdb/onlinedocs/gdb/GDB_002fMI-Breakpoint-Commands.html
+func (c *gdbCmd) BreakWatch() gdbMiResponse {
+	access := c.flagSet.Bool("a", false, "")
+	read := c.flagSet.Bool("r", false, "")
+	err := c.flagSet.Parse(c.args)
+	if err != nil {
+		return returnErrorf("Error parsing arguments %s: %s", c.argsStr, err)
+	}
+	args := c.flagSet.Args()
+	if len(args) == 0 {
+		return returnErrorf("Usage: -break-watch [-a|-r] EXPRESSION")
+	}
+	k := c.frontendRequest.kabuta
+	if k.dlvRpcClient == nil {
+		return returnErrorf("The program is not being run.")
+	}
+	expr := strings.Join(args, " ")
+	watchType := api.WatchWrite
+	if *access {
+		watchType = api.WatchRead | api.WatchWrite
+	} else if *read {
+		watchType = api.WatchRead
+	}
+
+	scope := k.evalScope()
+	// Remember the value and where it was evaluated, so that old and
+	// new values can be reported when the watchpoint triggers.
+	v, err := k.eval(expr, scope)
+	if err != nil {
+		return returnErrorf("Cannot watch %s: %s", expr, err)
+	}
+	in := rpc2.CreateWatchpointIn{Scope: scope, Expr: expr, Type: watchType}
+	out := rpc2.CreateWatchpointOut{}
+	err = k.dlvRpcClient.Call("RPCServer.CreateWatchpoint", in, &out)
+	if err != nil {
+		return returnErrorf("Cannot watch %s: %s", expr, err)
+	}
+	bp := &breakpoint{
+		rawLocation:          expr,
+		breakpointType:       breakpointTypeWatchpoint,
+		dlvBreakpoint:        out.Breakpoint,
+		watchValue:           v.SinglelineString(),
+		watchScope:           scope,
+		watchFrameFromBottom: -1,
+	}
+	frames, err := k.stacktrace(scope.GoroutineID, maxStackDepth)
+	if err == nil && scope.Frame < len(frames) {
+		bp.watchFrameFromBottom = len(frames) - scope.Frame
+		bp.watchFunction = functionName(frames[scope.Frame].Location)
+	}
+	k.addBreakpoint(bp)
+	k.log("Set watchpoint %v", bp.dlvBreakpoint)
+	return gdbMiResponse{results: f("%s={number=\"%d\",exp=%s}", bp.watchpointKind(), bp.number, miQuote(expr))}
+}
+
+// watchpointKind returns how GDB/MI names the kind of watchpoint
+// in results: wpt, hw-rwpt or hw-awpt.
+func (bp *breakpoint) watchpointKind() string {
+	switch bp.dlvBreakpoint.WatchType {
+	case api.WatchRead:
+		return "hw-rwpt"
+	case api.WatchRead | api.WatchWrite:
+		return "hw-awpt"
+	default:
+		return "wpt"
+	}
+}
+
+// watchpointType returns the type of watchpoint as shown by break-list.
+func (bp *breakpoint) watchpointType() string {
+	switch bp.dlvBreakpoint.WatchType {
+	case api.WatchRead:
+		return "read watchpoint"
+	case api.WatchRead | api.WatchWrite:
+		return "acc watchpoint"
+	default:
+		return "hw watchpoint"
+	}
+}
+
+// watchpointTriggerResults creates the part of *stopped record results
+// describing the triggered watchpoint, e.g.:
+// reason="watchpoint-trigger",wpt={number="2",exp="x"},value={old="1",new="2"}
+// If the value cannot be read anymore, only the old one is given.
+func (k *kabuta) watchpointTriggerResults(bp *breakpoint) string {
+	var reason string
+	switch bp.dlvBreakpoint.WatchType {
+	case api.WatchRead:
+		reason = "read-watchpoint-trigger"
+	case api.WatchRead | api.WatchWrite:
+		reason = "access-watchpoint-trigger"
+	default:
+		reason = "watchpoint-trigger"
+	}
+	results := f("reason=\"%s\",%s={number=\"%d\",exp=%s},", reason, bp.watchpointKind(), bp.number, miQuote(bp.rawLocation))
+	scope, err := k.watchScope(bp)
+	var v *api.Variable
+	if err == nil {
+		v, err = k.eval(bp.rawLocation, scope)
+	}
+	if err != nil {
+		// Repeating the old value would tell that it did not change.
+		k.log("Error reading value of watchpoint %d (%s): %s", bp.number, bp.rawLocation, err)
+		return results + f("value={old=%s},", miQuote(bp.watchValue))
+	}
+	newValue := v.SinglelineString()
+	if newValue == bp.watchValue {
+		// Read (or access by reading) does not change the value.
+		results += f("value={value=%s},", miQuote(newValue))
+	} else {
+		results += f("value={old=%s,new=%s},", miQuote(bp.watchValue), miQuote(newValue))
+	}
+	bp.watchValue = newValue
+	return results
+}
+
+// watchScope returns the scope the watchpoint's expression is evaluated
+// in now: the frame it was set in, found in the goroutine's stack by its
+// distance from the bottom, as that of a varobj is (see varobjScope()).
+func (k *kabuta) watchScope(bp *breakpoint) (api.EvalScope, error) {
+	scope := bp.watchScope
+	if bp.watchFrameFromBottom < 0 {
+		return scope, nil
+	}
+	frames, err := k.stacktrace(scope.GoroutineID, maxStackDepth)
+	if err != nil {
+		return api.EvalScope{}, err
+	}
+	level := len(frames) - bp.watchFrameFromBottom
+	if level < 0 || level >= len(frames) || functionName(frames[level].Location) != bp.watchFunction {
+		return api.EvalScope{}, NewError("Frame of %s is gone", bp.rawLocation)
+	}
+	scope.Frame = level
+	return scope, nil
+}
+
+// watchpointScopeResults handles watchpoints on stack-allocated variables
+// that Delve has removed because their frames are gone. The first one is
+// reported as the reason of the stop, e.g.:
+// reason="watchpoint-scope",wpnum="2",
+// and all of them are forgotten.
+func (k *kabuta) watchpointScopeResults(state *api.DebuggerState) string {
+	results := ""
+	for _, dlvBp := range state.WatchOutOfScope {
+		bp := k.breakpointByDlvID(dlvBp.ID)
+		if bp == nil {
+			continue
+		}
+		if results == "" {
+			results = f("reason=\"watchpoint-scope\",wpnum=\"%d\",", bp.number)
+		}
+		k.log("Watchpoint %d (%s) went out of scope", bp.number, bp.rawLocation)
+		delete(k.breakpoints, bp.number)
+	}
+	return results
+}